@@ -0,0 +1,710 @@
+// Package acme is a minimal RFC 8555 server issuing from a pki.Authority,
+// so standard ACME clients can get certificates without a public CA. It
+// supports accounts, orders for dns identifiers validated by http-01 and
+// finalization; state lives in memory and is lost on restart.
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+)
+
+const (
+	statusPending    = "pending"
+	statusReady      = "ready"
+	statusProcessing = "processing"
+	statusValid      = "valid"
+	statusInvalid    = "invalid"
+
+	orderLifetime = 7 * 24 * time.Hour
+	maxBodySize   = 1 << 20
+)
+
+// problem is an RFC 7807 problem document with an ACME error type.
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func newProblem(status int, kind, format string, v ...any) *problem {
+	return &problem{Type: "urn:ietf:params:acme:error:" + kind, Detail: fmt.Sprintf(format, v...), Status: status}
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type account struct {
+	id      string
+	key     crypto.PublicKey
+	jwk     jwk
+	contact []string
+	orders  []string
+}
+
+type order struct {
+	id          string
+	accountID   string
+	status      string
+	expires     time.Time
+	identifiers []identifier
+	authzs      []string
+	certificate []byte
+	err         *problem
+}
+
+type authorization struct {
+	id         string
+	accountID  string
+	identifier identifier
+	status     string
+	expires    time.Time
+	challenge  *challenge
+}
+
+type challenge struct {
+	id        string
+	authzID   string
+	token     string
+	status    string
+	validated time.Time
+	err       *problem
+}
+
+// Server answers ACME requests under Prefix, e.g. /acme with the directory
+// at /acme/directory.
+type Server struct {
+	Authority *pki.Authority
+	Prefix    string
+	// AutoApprove marks challenges valid without fetching them, for test
+	// environments where the names do not resolve to the clients.
+	AutoApprove bool
+	// ValidationAddr, when set, is dialed for every http-01 check instead
+	// of the identifier at HTTPPort, e.g. a local proxy at 127.0.0.1:5002.
+	ValidationAddr string
+	// HTTPPort is the port of http-01 checks, 80 when 0.
+	HTTPPort int
+	// Client fetches the http-01 responses, a client with a 10 second
+	// timeout when nil.
+	Client *http.Client
+
+	mu           sync.Mutex
+	nonces       map[string]time.Time
+	accounts     map[string]*account
+	byThumbprint map[string]*account
+	orders       map[string]*order
+	authzs       map[string]*authorization
+	challenges   map[string]*challenge
+}
+
+func NewServer(authority *pki.Authority, prefix string) *Server {
+	return &Server{
+		Authority:    authority,
+		Prefix:       strings.TrimSuffix(prefix, "/"),
+		nonces:       map[string]time.Time{},
+		accounts:     map[string]*account{},
+		byThumbprint: map[string]*account{},
+		orders:       map[string]*order{},
+		authzs:       map[string]*authorization{},
+		challenges:   map[string]*challenge{},
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *Server) newNonce() string {
+	nonce := randomID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.nonces) > 10000 {
+		for n, issued := range s.nonces {
+			if time.Since(issued) > time.Hour {
+				delete(s.nonces, n)
+			}
+		}
+	}
+	s.nonces[nonce] = time.Now()
+	return nonce
+}
+
+func (s *Server) useNonce(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.nonces[nonce]
+	delete(s.nonces, nonce)
+	return ok
+}
+
+// urlOf is the absolute URL of a resource, base being scheme and host of
+// the request.
+func (s *Server) urlOf(base, resource, id string) string {
+	if id == "" {
+		return base + s.Prefix + "/" + resource
+	}
+	return base + s.Prefix + "/" + resource + "/" + id
+}
+
+func baseOf(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeProblem(w http.ResponseWriter, p *problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base := baseOf(r)
+	resource, id, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, s.Prefix), "/"), "/")
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Link", "<"+s.urlOf(base, "directory", "")+`>;rel="index"`)
+
+	switch {
+	case resource == "directory" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{
+			"newNonce":   s.urlOf(base, "new-nonce", ""),
+			"newAccount": s.urlOf(base, "new-account", ""),
+			"newOrder":   s.urlOf(base, "new-order", ""),
+			"meta":       map[string]any{"externalAccountRequired": false},
+		})
+		return
+	case resource == "new-nonce" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+		return
+	case resource == "new-nonce" && r.Method == http.MethodGet:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case r.Method != http.MethodPost:
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, "malformed", "%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+
+	req, p := s.parse(r, base+r.URL.Path, resource == "new-account")
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	switch resource {
+	case "new-account":
+		s.newAccount(w, req, base)
+	case "account":
+		s.account(w, req, base, id)
+	case "orders":
+		s.accountOrders(w, req, base, id)
+	case "new-order":
+		s.newOrder(w, req, base)
+	case "order":
+		s.order(w, req, base, id)
+	case "authz":
+		s.authorization(w, req, base, id)
+	case "chall":
+		s.challenge(w, req, base, id)
+	case "finalize":
+		s.finalize(w, req, base, id)
+	case "cert":
+		s.certificate(w, req, id)
+	default:
+		writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown resource %s", r.URL.Path))
+	}
+}
+
+// request is a POST whose JWS was verified.
+type request struct {
+	payload []byte
+	jwk     jwk
+	key     crypto.PublicKey
+	account *account
+}
+
+// parse verifies the JWS body of a POST to url. newAccount requests carry
+// the key as jwk, all others the account URL as kid.
+func (s *Server) parse(r *http.Request, url string, newAccount bool) (*request, *problem) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "malformed", "read body failed, error %v", err)
+	}
+	var message jws
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, newProblem(http.StatusBadRequest, "malformed", "body is not a flattened JWS, error %v", err)
+	}
+	protected, err := decodeBase64(message.Protected)
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "malformed", "invalid protected header encoding")
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, newProblem(http.StatusBadRequest, "malformed", "invalid protected header, error %v", err)
+	}
+	if header.URL != url {
+		return nil, newProblem(http.StatusUnauthorized, "unauthorized", "url %q of the request does not match %q", header.URL, url)
+	}
+	if !s.useNonce(header.Nonce) {
+		return nil, newProblem(http.StatusBadRequest, "badNonce", "nonce %q is unknown or used", header.Nonce)
+	}
+	req := &request{}
+	switch {
+	case newAccount && len(header.JWK) > 0 && header.KID == "":
+		if err := json.Unmarshal(header.JWK, &req.jwk); err != nil {
+			return nil, newProblem(http.StatusBadRequest, "malformed", "invalid jwk, error %v", err)
+		}
+		if req.key, err = req.jwk.publicKey(); err != nil {
+			return nil, newProblem(http.StatusBadRequest, "badPublicKey", "%v", err)
+		}
+	case !newAccount && len(header.JWK) == 0 && header.KID != "":
+		prefix := strings.TrimSuffix(url, r.URL.Path) + s.Prefix + "/account/"
+		s.mu.Lock()
+		req.account = s.accounts[strings.TrimPrefix(header.KID, prefix)]
+		s.mu.Unlock()
+		if !strings.HasPrefix(header.KID, prefix) || req.account == nil {
+			return nil, newProblem(http.StatusBadRequest, "accountDoesNotExist", "no account %q", header.KID)
+		}
+		req.jwk, req.key = req.account.jwk, req.account.key
+	case newAccount:
+		return nil, newProblem(http.StatusBadRequest, "malformed", "new account requests are signed with a jwk")
+	default:
+		return nil, newProblem(http.StatusBadRequest, "malformed", "requests are signed with the kid of the account")
+	}
+	signature, err := decodeBase64(message.Signature)
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "malformed", "invalid signature encoding")
+	}
+	if err := verify(header.Alg, req.key, []byte(message.Protected+"."+message.Payload), signature); err != nil {
+		return nil, newProblem(http.StatusBadRequest, "malformed", "jws verification failed, error %v", err)
+	}
+	if req.payload, err = decodeBase64(message.Payload); err != nil {
+		return nil, newProblem(http.StatusBadRequest, "malformed", "invalid payload encoding")
+	}
+	return req, nil
+}
+
+// decodePayload unmarshals a JSON payload, an empty POST-as-GET payload
+// leaves v unchanged.
+func (req *request) decodePayload(v any) *problem {
+	if len(req.payload) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.payload, v); err != nil {
+		return newProblem(http.StatusBadRequest, "malformed", "invalid payload, error %v", err)
+	}
+	return nil
+}
+
+func (s *Server) accountJSON(base string, a *account) map[string]any {
+	return map[string]any{"status": statusValid, "contact": a.contact, "orders": s.urlOf(base, "orders", a.id)}
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, req *request, base string) {
+	var payload struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if p := req.decodePayload(&payload); p != nil {
+		writeProblem(w, p)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	thumbprint := req.jwk.thumbprint()
+	if existing := s.byThumbprint[thumbprint]; existing != nil {
+		w.Header().Set("Location", s.urlOf(base, "account", existing.id))
+		writeJSON(w, http.StatusOK, s.accountJSON(base, existing))
+		return
+	}
+	if payload.OnlyReturnExisting {
+		writeProblem(w, newProblem(http.StatusBadRequest, "accountDoesNotExist", "no account for this key"))
+		return
+	}
+	a := &account{id: randomID(), key: req.key, jwk: req.jwk, contact: payload.Contact}
+	s.accounts[a.id] = a
+	s.byThumbprint[thumbprint] = a
+	log.Printf("acme new account %s, contact %v", a.id, a.contact)
+	w.Header().Set("Location", s.urlOf(base, "account", a.id))
+	writeJSON(w, http.StatusCreated, s.accountJSON(base, a))
+}
+
+func (s *Server) account(w http.ResponseWriter, req *request, base, id string) {
+	var payload struct {
+		Contact []string `json:"contact"`
+	}
+	if p := req.decodePayload(&payload); p != nil {
+		writeProblem(w, p)
+		return
+	}
+	if req.account.id != id {
+		writeProblem(w, newProblem(http.StatusUnauthorized, "unauthorized", "account %s is not the signer", id))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if payload.Contact != nil {
+		req.account.contact = payload.Contact
+	}
+	writeJSON(w, http.StatusOK, s.accountJSON(base, req.account))
+}
+
+func (s *Server) accountOrders(w http.ResponseWriter, req *request, base, id string) {
+	if req.account.id != id {
+		writeProblem(w, newProblem(http.StatusUnauthorized, "unauthorized", "account %s is not the signer", id))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls := []string{}
+	for _, orderID := range req.account.orders {
+		urls = append(urls, s.urlOf(base, "order", orderID))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"orders": urls})
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, req *request, base string) {
+	var payload struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	if p := req.decodePayload(&payload); p != nil {
+		writeProblem(w, p)
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "an order needs identifiers"))
+		return
+	}
+	for _, id := range payload.Identifiers {
+		if id.Type != "dns" {
+			writeProblem(w, newProblem(http.StatusBadRequest, "unsupportedIdentifier", "identifier type %q is not supported, only dns", id.Type))
+			return
+		}
+		if strings.HasPrefix(id.Value, "*.") {
+			writeProblem(w, newProblem(http.StatusBadRequest, "rejectedIdentifier", "wildcard %q needs dns-01, only http-01 is supported", id.Value))
+			return
+		}
+		if _, err := pki.ParseSANs([]string{"DNS:" + id.Value}); err != nil {
+			writeProblem(w, newProblem(http.StatusBadRequest, "rejectedIdentifier", "%v", err))
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := time.Now().Add(orderLifetime)
+	o := &order{id: randomID(), accountID: req.account.id, status: statusPending, expires: expires, identifiers: payload.Identifiers}
+	for _, id := range payload.Identifiers {
+		authz := &authorization{id: randomID(), accountID: req.account.id, identifier: id, status: statusPending, expires: expires}
+		authz.challenge = &challenge{id: randomID(), authzID: authz.id, token: randomID() + randomID(), status: statusPending}
+		s.authzs[authz.id] = authz
+		s.challenges[authz.challenge.id] = authz.challenge
+		o.authzs = append(o.authzs, authz.id)
+	}
+	s.orders[o.id] = o
+	req.account.orders = append(req.account.orders, o.id)
+	w.Header().Set("Location", s.urlOf(base, "order", o.id))
+	writeJSON(w, http.StatusCreated, s.orderJSON(base, o))
+}
+
+// refresh moves an order on once its authorizations are done. Callers hold
+// s.mu.
+func (s *Server) refresh(o *order) {
+	if o.status == statusPending || o.status == statusReady {
+		if time.Now().After(o.expires) {
+			o.status = statusInvalid
+			return
+		}
+	}
+	if o.status != statusPending {
+		return
+	}
+	valid := 0
+	for _, id := range o.authzs {
+		switch s.authzs[id].status {
+		case statusInvalid:
+			o.status = statusInvalid
+			o.err = s.authzs[id].challenge.err
+			return
+		case statusValid:
+			valid++
+		}
+	}
+	if valid == len(o.authzs) {
+		o.status = statusReady
+	}
+}
+
+func (s *Server) orderJSON(base string, o *order) map[string]any {
+	s.refresh(o)
+	authzs := []string{}
+	for _, id := range o.authzs {
+		authzs = append(authzs, s.urlOf(base, "authz", id))
+	}
+	object := map[string]any{
+		"status":         o.status,
+		"expires":        o.expires.UTC().Format(time.RFC3339),
+		"identifiers":    o.identifiers,
+		"authorizations": authzs,
+		"finalize":       s.urlOf(base, "finalize", o.id),
+	}
+	if o.certificate != nil {
+		object["certificate"] = s.urlOf(base, "cert", o.id)
+	}
+	if o.err != nil {
+		object["error"] = o.err
+	}
+	return object
+}
+
+func (s *Server) challengeJSON(base string, c *challenge) map[string]any {
+	object := map[string]any{"type": "http-01", "url": s.urlOf(base, "chall", c.id), "token": c.token, "status": c.status}
+	if !c.validated.IsZero() {
+		object["validated"] = c.validated.UTC().Format(time.RFC3339)
+	}
+	if c.err != nil {
+		object["error"] = c.err
+	}
+	return object
+}
+
+// lookupOrder returns the order id of the signing account. Callers hold
+// s.mu.
+func (s *Server) lookupOrder(req *request, id string) (*order, *problem) {
+	o := s.orders[id]
+	if o == nil {
+		return nil, newProblem(http.StatusNotFound, "malformed", "no order %s", id)
+	}
+	if o.accountID != req.account.id {
+		return nil, newProblem(http.StatusUnauthorized, "unauthorized", "order %s belongs to another account", id)
+	}
+	return o, nil
+}
+
+func (s *Server) order(w http.ResponseWriter, req *request, base, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, p := s.lookupOrder(req, id)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.orderJSON(base, o))
+}
+
+func (s *Server) authorization(w http.ResponseWriter, req *request, base, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authz := s.authzs[id]
+	if authz == nil || authz.accountID != req.account.id {
+		writeProblem(w, newProblem(http.StatusNotFound, "malformed", "no authorization %s for this account", id))
+		return
+	}
+	if authz.status == statusPending && time.Now().After(authz.expires) {
+		authz.status = statusInvalid
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":     authz.status,
+		"expires":    authz.expires.UTC().Format(time.RFC3339),
+		"identifier": authz.identifier,
+		"challenges": []any{s.challengeJSON(base, authz.challenge)},
+	})
+}
+
+// challenge starts the validation of a pending challenge and answers with
+// its outcome, the check runs before the response is sent.
+func (s *Server) challenge(w http.ResponseWriter, req *request, base, id string) {
+	s.mu.Lock()
+	c := s.challenges[id]
+	if c == nil || s.authzs[c.authzID].accountID != req.account.id {
+		s.mu.Unlock()
+		writeProblem(w, newProblem(http.StatusNotFound, "malformed", "no challenge %s for this account", id))
+		return
+	}
+	authz := s.authzs[c.authzID]
+	start := c.status == statusPending && len(req.payload) > 0
+	if start {
+		c.status = statusProcessing
+	}
+	s.mu.Unlock()
+
+	if start {
+		var p *problem
+		if !s.AutoApprove {
+			p = s.validate(authz.identifier.Value, c.token+"."+req.jwk.thumbprint())
+		}
+		s.mu.Lock()
+		if p != nil {
+			c.status, c.err, authz.status = statusInvalid, p, statusInvalid
+			log.Printf("acme http-01 challenge for %s failed, %s", authz.identifier.Value, p.Detail)
+		} else {
+			c.status, c.validated, authz.status = statusValid, time.Now(), statusValid
+		}
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Link", "<"+s.urlOf(base, "authz", authz.id)+`>;rel="up"`)
+	writeJSON(w, http.StatusOK, s.challengeJSON(base, c))
+}
+
+// validate fetches the http-01 key authorization of domain.
+func (s *Server) validate(domain, keyAuthorization string) *problem {
+	addr := s.ValidationAddr
+	if addr == "" {
+		port := s.HTTPPort
+		if port == 0 {
+			port = 80
+		}
+		addr = net.JoinHostPort(domain, strconv.Itoa(port))
+	}
+	token, _, _ := strings.Cut(keyAuthorization, ".")
+	httpRequest, err := http.NewRequest(http.MethodGet, "http://"+addr+"/.well-known/acme-challenge/"+token, nil)
+	if err != nil {
+		return newProblem(http.StatusBadRequest, "connection", "build request failed, error %v", err)
+	}
+	httpRequest.Host = domain
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Do(httpRequest)
+	if err != nil {
+		return newProblem(http.StatusBadRequest, "connection", "fetch %s for %s failed, error %v", httpRequest.URL, domain, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return newProblem(http.StatusForbidden, "incorrectResponse", "%s for %s answered %s", httpRequest.URL, domain, response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 4096))
+	if err != nil {
+		return newProblem(http.StatusBadRequest, "connection", "read %s for %s failed, error %v", httpRequest.URL, domain, err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return newProblem(http.StatusForbidden, "incorrectResponse", "%s for %s does not hold the key authorization", httpRequest.URL, domain)
+	}
+	return nil
+}
+
+// csrNames are the DNS names a CSR asks for, its common name included.
+func csrNames(csr *x509.CertificateRequest) []string {
+	var names []string
+	for _, name := range append([]string{csr.Subject.CommonName}, csr.DNSNames...) {
+		if name = strings.ToLower(name); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (s *Server) finalize(w http.ResponseWriter, req *request, base, id string) {
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if p := req.decodePayload(&payload); p != nil {
+		writeProblem(w, p)
+		return
+	}
+	s.mu.Lock()
+	o, p := s.lookupOrder(req, id)
+	if p == nil {
+		if s.refresh(o); o.status != statusReady {
+			p = newProblem(http.StatusForbidden, "orderNotReady", "order %s is %s", id, o.status)
+		}
+	}
+	var wanted []string
+	if p == nil {
+		for _, identifier := range o.identifiers {
+			if name := strings.ToLower(identifier.Value); !slices.Contains(wanted, name) {
+				wanted = append(wanted, name)
+			}
+		}
+		slices.Sort(wanted)
+	}
+	s.mu.Unlock()
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	der, err := decodeBase64(payload.CSR)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, "badCSR", "invalid csr encoding"))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, "badCSR", "invalid csr, error %v", err))
+		return
+	}
+	if names := csrNames(csr); !slices.Equal(names, wanted) || len(csr.IPAddresses) > 0 || len(csr.URIs) > 0 || len(csr.EmailAddresses) > 0 {
+		writeProblem(w, newProblem(http.StatusBadRequest, "badCSR", "csr names %v differ from the order identifiers %v", names, wanted))
+		return
+	}
+
+	s.mu.Lock()
+	if o.status != statusReady {
+		s.mu.Unlock()
+		writeProblem(w, newProblem(http.StatusForbidden, "orderNotReady", "order %s is %s", id, o.status))
+		return
+	}
+	o.status = statusProcessing
+	s.mu.Unlock()
+	certBlock, cert, err := s.Authority.Issue(nil, pki.EncodeCSR(der), pki.CertOptions{Profile: "tls-server"})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		o.status = statusReady
+		writeProblem(w, newProblem(http.StatusBadRequest, "badCSR", "%v", err))
+		return
+	}
+	o.status, o.certificate = statusValid, append(certBlock, s.Authority.ChainPEM()...)
+	log.Printf("acme issued serial %s for %v to account %s", utils.FormatSerial(cert.SerialNumber), wanted, req.account.id)
+	w.Header().Set("Location", s.urlOf(base, "order", o.id))
+	writeJSON(w, http.StatusOK, s.orderJSON(base, o))
+}
+
+func (s *Server) certificate(w http.ResponseWriter, req *request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, p := s.lookupOrder(req, id)
+	if p == nil && o.certificate == nil {
+		p = newProblem(http.StatusNotFound, "malformed", "order %s has no certificate", id)
+	}
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(o.certificate)
+}
@@ -0,0 +1,207 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+	acmeclient "golang.org/x/crypto/acme"
+)
+
+func newTestServer(t *testing.T) (*Server, *acmeclient.Client) {
+	dir := t.TempDir()
+	caKey, err := pki.GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBlock, err := pki.SignCACert(nil, caKey, "DevCA", pki.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBlock, err := utils.EncodePrivateKey(caKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertPath, caKeyPath := filepath.Join(dir, "ca1.crt"), filepath.Join(dir, "ca1.key")
+	if err := pki.WriteFile(caCertPath, caBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := pki.WriteFile(caKeyPath, keyBlock); err != nil {
+		t.Fatal(err)
+	}
+	authority, err := pki.LoadAuthority(caCertPath, "", caKeyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(authority, "/acme")
+	mux := http.NewServeMux()
+	mux.Handle("/acme/", server)
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	accountKey, err := pki.GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acmeclient.Client{Key: accountKey, DirectoryURL: ts.URL + "/acme/directory", HTTPClient: ts.Client()}
+	if _, err := client.Register(context.Background(), &acmeclient.Account{Contact: []string{"mailto:ops@dev.local"}}, acmeclient.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+// http01 serves key authorizations like a client's standalone responder.
+type http01 struct {
+	mu        sync.Mutex
+	responses map[string]string
+}
+
+func (h *http01) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	response, ok := h.responses[strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(response))
+}
+
+// authorize answers every http-01 challenge of the order, with the right
+// key authorization unless tamper is set.
+func authorize(t *testing.T, client *acmeclient.Client, responder *http01, order *acmeclient.Order, tamper bool) error {
+	ctx := context.Background()
+	for _, url := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		challenge := authz.Challenges[0]
+		if challenge.Type != "http-01" {
+			t.Fatalf("challenge type is %s, want http-01", challenge.Type)
+		}
+		response, err := client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tamper {
+			response += "x"
+		}
+		responder.mu.Lock()
+		responder.responses[challenge.Token] = response
+		responder.mu.Unlock()
+		if _, err := client.Accept(ctx, challenge); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func finalize(t *testing.T, client *acmeclient.Client, order *acmeclient.Order, names ...string) ([][]byte, error) {
+	key, err := pki.GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(nil, &x509.CertificateRequest{Subject: pkix.Name{CommonName: names[0]}, DNSNames: names}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ders, _, err := client.CreateOrderCert(context.Background(), order.FinalizeURL, csr, true)
+	return ders, err
+}
+
+func TestHTTP01Flow(t *testing.T) {
+	server, client := newTestServer(t)
+	responder := &http01{responses: map[string]string{}}
+	challengeServer := httptest.NewServer(responder)
+	defer challengeServer.Close()
+	server.ValidationAddr = strings.TrimPrefix(challengeServer.URL, "http://")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	order, err := client.AuthorizeOrder(ctx, acmeclient.DomainIDs("node1.dev.local", "api.dev.local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := finalize(t, client, order, "node1.dev.local", "api.dev.local"); err == nil {
+		t.Error("expected finalizing a pending order to fail")
+	}
+	if err := authorize(t, client, responder, order, false); err != nil {
+		t.Fatal(err)
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != acmeclient.StatusReady {
+		t.Fatalf("order is %s, want ready", order.Status)
+	}
+	if _, err := finalize(t, client, order, "node1.dev.local", "admin.dev.local"); err == nil {
+		t.Error("expected a csr with other names than the order to be rejected")
+	}
+	ders, err := finalize(t, client, order, "node1.dev.local", "api.dev.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ders) != 2 {
+		t.Fatalf("got %d certificates, want the leaf and the ca", len(ders))
+	}
+	leaf, err := x509.ParseCertificate(ders[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.CheckSignatureFrom(server.Authority.Cert); err != nil {
+		t.Errorf("leaf not signed by the ca, error %v", err)
+	}
+	if len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth || len(leaf.DNSNames) != 2 {
+		t.Errorf("leaf has ext key usage %v and names %v", leaf.ExtKeyUsage, leaf.DNSNames)
+	}
+	entries, err := server.Authority.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Serial.Cmp(leaf.SerialNumber) != 0 {
+		t.Errorf("database holds %v, want the issued leaf", entries)
+	}
+
+	bad, err := client.AuthorizeOrder(ctx, acmeclient.DomainIDs("node2.dev.local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authorize(t, client, responder, bad, true); err == nil {
+		t.Error("expected a wrong key authorization to invalidate the authorization")
+	}
+	if _, err := client.AuthorizeOrder(ctx, acmeclient.DomainIDs("*.dev.local")); err == nil {
+		t.Error("expected a wildcard order to be rejected")
+	}
+}
+
+func TestAutoApprove(t *testing.T) {
+	server, client := newTestServer(t)
+	server.AutoApprove = true
+	// nothing listens here, auto approval must not fetch
+	server.ValidationAddr = "127.0.0.1:1"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	order, err := client.AuthorizeOrder(ctx, acmeclient.DomainIDs("kafka.docker"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authorize(t, client, &http01{responses: map[string]string{}}, order, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := finalize(t, client, order, "kafka.docker"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"example.com/lx/beego/dev/acme"
+)
+
+// acmeServer serves the CA of -ca-cert to ACME clients under /acme.
+func (f *caServerFlags) acmeServer(ca *caServer) *acme.Server {
+	server := acme.NewServer(ca.Authority, "/acme")
+	server.AutoApprove = *f.acmeAutoApprove
+	server.ValidationAddr = *f.acmeValidationAddr
+	server.HTTPPort = *f.acmeHTTPPort
+	logger.Info("serve acme directory /acme/directory, auto approve %v, validation address %q", server.AutoApprove, server.ValidationAddr)
+	return server
+}
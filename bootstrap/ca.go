@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+	"github.com/beego/beego/v2/server/web"
+)
+
+// certAuthority is the CA served under /ca, nil without -ca-cert.
+var certAuthority *caServer
+
+// caServer is the CA of the server and who may operate it. Operators are
+// clients whose verified certificate carries one of the operatorIDs SPIFFE
+// IDs, or was issued by this CA with one of the operatorSerials.
+type caServer struct {
+	*pki.Authority
+	opts            pki.CertOptions
+	operatorIDs     []string
+	operatorSerials []*big.Int
+}
+
+// caServerFlags are the flags of the CA endpoints, parsed together with
+// the other server flags.
+type caServerFlags struct {
+	cert, key, keyBackend  *string
+	password, passwordFile *string
+	chain, policy          *string
+	crlURL, ocspURL        *string
+	crlDays                *int
+	operators              *string
+	acmeAutoApprove        *bool
+	acmeValidationAddr     *string
+	acmeHTTPPort           *int
+}
+
+func registerCAServerFlags(flags *flag.FlagSet) *caServerFlags {
+	return &caServerFlags{
+		cert:         flags.String("ca-cert", "", "serve this CA under /ca, signing CSRs submitted by verified clients"),
+		key:          flags.String("ca-key", "", "private key of -ca-cert, a PEM file or a pkcs11: URI, defaults to the certificate path with .key"),
+		keyBackend:   flags.String("ca-key-backend", "", "key backend of -ca-key, one of "+strings.Join(pki.KeyBackends, ", ")+", chosen from -ca-key when empty"),
+		password:     flags.String("ca-key-password", "", "password of an encrypted CA key or PIN of a pkcs11 token"),
+		passwordFile: flags.String("ca-key-password-file", "", "file holding the password of an encrypted CA key or PIN of a pkcs11 token"),
+		chain:        flags.String("ca-chain", "", "PEM certificates above -ca-cert, returned with the CA and every issued certificate"),
+		policy:       flags.String("ca-policy", "", "YAML or JSON policy checked before signing, defaults to <ca>.policy.yaml when present"),
+		crlURL:       flags.String("ca-crl-url", "", "CRL distribution point written into issued certificates"),
+		ocspURL:      flags.String("ca-ocsp-url", "", "AIA OCSP URL written into issued certificates"),
+		crlDays:      flags.Int("ca-crl-days", 30, "days until the next update of the CRL written after a revocation"),
+		operators:    flags.String("operators", "", "comma separated SPIFFE IDs, or hexadecimal serials of certificates issued by -ca-cert, of clients allowed to list and revoke"),
+		// used by the acmedev mode only
+		acmeAutoApprove:    flags.Bool("acme-auto-approve", false, "mark ACME challenges valid without fetching them, for test environments"),
+		acmeValidationAddr: flags.String("acme-validation-addr", "", "host:port every http-01 check connects to instead of the identifier, e.g. 127.0.0.1:5002"),
+		acmeHTTPPort:       flags.Int("acme-http-port", 80, "port of the identifier http-01 checks connect to"),
+	}
+}
+
+// load reads the CA named by -ca-cert, nil when it is not set.
+func (f *caServerFlags) load() (*caServer, error) {
+	if *f.cert == "" {
+		return nil, nil
+	}
+	keyPath := *f.key
+	if keyPath == "" {
+		keyPath = utils.CAPrefix(*f.cert) + ".key"
+	}
+	password, err := utils.ResolvePassword(*f.password, *f.passwordFile, "CA_KEY_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	authority, err := pki.LoadAuthority(*f.cert, *f.keyBackend, keyPath, password)
+	if err != nil {
+		return nil, err
+	}
+	if *f.chain != "" {
+		if err := authority.AddChain(*f.chain); err != nil {
+			return nil, err
+		}
+	}
+	policyPath := *f.policy
+	if policyPath == "" && fileExists(pki.PolicyPath(*f.cert)) {
+		policyPath = pki.PolicyPath(*f.cert)
+	}
+	if policyPath != "" {
+		if authority.Policy, err = pki.LoadPolicy(policyPath); err != nil {
+			return nil, err
+		}
+	} else {
+		logger.Warn("no policy for ca %s, issuing only profiles %v", authority.Cert.Subject.CommonName, pki.DefaultProfiles)
+	}
+	authority.CRLDays = *f.crlDays
+	server := &caServer{Authority: authority}
+	if *f.crlURL != "" {
+		server.opts.CRLDistributionPoints = []string{*f.crlURL}
+	}
+	if *f.ocspURL != "" {
+		server.opts.OCSPServers = []string{*f.ocspURL}
+	}
+	if *f.operators != "" {
+		for _, operator := range strings.Split(*f.operators, ",") {
+			if strings.HasPrefix(operator, "spiffe://") {
+				id, err := pki.ParseSPIFFEID(operator)
+				if err != nil {
+					return nil, err
+				}
+				server.operatorIDs = append(server.operatorIDs, id.String())
+				continue
+			}
+			serial, err := utils.ParseSerial(operator)
+			if err != nil {
+				return nil, fmt.Errorf("operator %q is neither a spiffe id nor a serial, error %v", operator, err)
+			}
+			server.operatorSerials = append(server.operatorSerials, serial)
+		}
+	}
+	logger.Info("serve ca %s under /ca, policy %q, operators %s", authority.Cert.Subject.CommonName, policyPath, *f.operators)
+	return server, nil
+}
+
+// isOperator reports whether the verified client certificate carries the
+// operator role.
+func (s *caServer) isOperator(state *tls.ConnectionState) bool {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return false
+	}
+	cert := state.VerifiedChains[0][0]
+	if id, err := pki.SPIFFEIDOf(cert); err == nil && slices.Contains(s.operatorIDs, id.String()) {
+		return true
+	}
+	if cert.CheckSignatureFrom(s.Cert) != nil {
+		return false
+	}
+	for _, serial := range s.operatorSerials {
+		if serial.Cmp(cert.SerialNumber) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEnrollment returns an error unless the client of state may submit
+// csrBlock. Operators enroll any names the policy allows. Other clients may
+// not ask for an operator SPIFFE ID and, without a policy limiting the
+// names, only for the subject and names of their own certificate.
+func (s *caServer) checkEnrollment(state *tls.ConnectionState, csrBlock []byte) error {
+	if s.isOperator(state) {
+		return nil
+	}
+	if s.requestsOperator(csrBlock) {
+		return errors.New("only operators may enroll an operator id")
+	}
+	if s.Policy == nil {
+		if err := pki.CheckSameIdentity(csrBlock, state.VerifiedChains[0][0]); err != nil {
+			return fmt.Errorf("without a ca policy only operators may enroll other names, %v", err)
+		}
+	}
+	return nil
+}
+
+// requestsOperator reports whether csrBlock asks for the SPIFFE ID of an
+// operator, which only operators may enroll.
+func (s *caServer) requestsOperator(csrBlock []byte) bool {
+	csr, err := pki.ParseCSR(csrBlock)
+	if err != nil {
+		return false
+	}
+	for _, uri := range csr.URIs {
+		if id, err := pki.ParseSPIFFEID(uri.String()); err == nil && slices.Contains(s.operatorIDs, id.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func registerCARoutes() {
+	// keep the raw CSR, beego parses bodies sent as forms otherwise
+	web.BConfig.CopyRequestBody = true
+	web.CtrlGet("/ca/chain", CAController.Chain)
+	web.CtrlPost("/ca/csr", CAController.Enroll)
+	web.CtrlGet("/ca/certs", CAController.List)
+	web.CtrlGet("/ca/certs/:serial", CAController.Certificate)
+	web.CtrlPost("/ca/certs/:serial/revoke", CAController.Revoke)
+}
+
+type CAController struct {
+	web.Controller
+}
+
+// caller names the client in logs, by SPIFFE ID or else the subject of its
+// certificate.
+func (c CAController) caller() string {
+	if id, _ := c.Ctx.Input.GetData(callerIDKey).(string); id != "" {
+		return id
+	}
+	if state := c.Ctx.Request.TLS; state != nil && len(state.VerifiedChains) > 0 {
+		return state.VerifiedChains[0][0].Subject.String()
+	}
+	return c.Ctx.Request.RemoteAddr
+}
+
+func (c CAController) fail(status int, err error) {
+	c.Ctx.Output.SetStatus(status)
+	c.Ctx.Output.JSON(map[string]string{"error": err.Error()}, false, false)
+}
+
+// verified answers 403 unless the client presented a certificate the
+// server verified, and with operator also requires the operator role.
+func (c CAController) verified(operator bool) bool {
+	state := c.Ctx.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 {
+		c.fail(http.StatusForbidden, errors.New("a verified client certificate is required"))
+		return false
+	}
+	if operator && !certAuthority.isOperator(state) {
+		logger.Warn("reject %s %s from %s, not an operator", c.Ctx.Request.Method, c.Ctx.Request.URL.Path, c.caller())
+		c.fail(http.StatusForbidden, errors.New("the operator role is required"))
+		return false
+	}
+	return true
+}
+
+func (c CAController) pem(content []byte) {
+	c.Ctx.Output.Header("Content-Type", "application/pem-certificate-chain")
+	c.Ctx.Output.Body(content)
+}
+
+// serial looks up the issued certificate named by the :serial parameter,
+// answering 400 or 404 when there is none.
+func (c CAController) serial() (*x509.Certificate, []byte, bool) {
+	serial, err := utils.ParseSerial(c.Ctx.Input.Param(":serial"))
+	if err != nil {
+		c.fail(http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	certBlock, _, err := certAuthority.Certificate(serial)
+	if errors.Is(err, os.ErrNotExist) {
+		c.fail(http.StatusNotFound, fmt.Errorf("no certificate with serial %s", utils.FormatSerial(serial)))
+		return nil, nil, false
+	} else if err != nil {
+		logger.Error("read certificate %s failed, error %v", utils.FormatSerial(serial), err)
+		c.fail(http.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+	cert, err := pki.ParseCertificate(certBlock)
+	if err != nil {
+		c.fail(http.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+	return cert, certBlock, true
+}
+
+// Chain returns the CA certificate and the certificates above it.
+func (c CAController) Chain() {
+	if c.verified(false) {
+		c.pem(certAuthority.ChainPEM())
+	}
+}
+
+// Enroll signs the PEM or DER CSR in the body, as far as checkEnrollment
+// allows the client, and returns the certificate followed by the CA chain.
+// The profile and days query parameters choose the profile and validity,
+// within the policy of the CA.
+func (c CAController) Enroll() {
+	if !c.verified(false) {
+		return
+	}
+	body := c.Ctx.Input.RequestBody
+	if len(body) == 0 {
+		c.fail(http.StatusBadRequest, errors.New("the body holds no csr"))
+		return
+	}
+	csrBlock := body
+	if block, _ := pem.Decode(body); block == nil {
+		csrBlock = pki.EncodeCSR(body)
+	}
+	if err := certAuthority.checkEnrollment(c.Ctx.Request.TLS, csrBlock); err != nil {
+		logger.Warn("reject csr from %s, error %v", c.caller(), err)
+		c.fail(http.StatusForbidden, err)
+		return
+	}
+	opts := certAuthority.opts
+	opts.Profile = c.Ctx.Input.Query("profile")
+	if days := c.Ctx.Input.Query("days"); days != "" {
+		var err error
+		if opts.ValidityDays, err = strconv.Atoi(days); err != nil || opts.ValidityDays <= 0 {
+			c.fail(http.StatusBadRequest, fmt.Errorf("invalid days %q", days))
+			return
+		}
+	}
+	certBlock, cert, err := certAuthority.Issue(rand.Reader, csrBlock, opts)
+	if err != nil {
+		logger.Warn("reject csr from %s, error %v", c.caller(), err)
+		c.fail(http.StatusBadRequest, err)
+		return
+	}
+	serial := utils.FormatSerial(cert.SerialNumber)
+	logger.Info("issued serial %s for %s to %s", serial, cert.Subject.String(), c.caller())
+	c.Ctx.Output.Header("Location", "/ca/certs/"+serial)
+	c.Ctx.Output.SetStatus(http.StatusCreated)
+	c.pem(append(certBlock, certAuthority.ChainPEM()...))
+}
+
+// Certificate returns an issued certificate by its hexadecimal serial.
+func (c CAController) Certificate() {
+	if !c.verified(false) {
+		return
+	}
+	if _, certBlock, ok := c.serial(); ok {
+		c.pem(certBlock)
+	}
+}
+
+type caRecord struct {
+	Serial    string     `json:"serial"`
+	Status    string     `json:"status"`
+	Subject   string     `json:"subject"`
+	NotAfter  time.Time  `json:"notAfter"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// List returns the database of the CA, the status query parameter V, R or
+// E keeps only valid, revoked or expired entries.
+func (c CAController) List() {
+	if !c.verified(true) {
+		return
+	}
+	entries, err := certAuthority.Entries()
+	if err != nil {
+		logger.Error("read ca database failed, error %v", err)
+		c.fail(http.StatusInternalServerError, err)
+		return
+	}
+	status := strings.ToUpper(c.Ctx.Input.Query("status"))
+	now := time.Now()
+	records := []caRecord{}
+	for _, entry := range entries {
+		record := caRecord{Serial: utils.FormatSerial(entry.Serial), Status: entry.Status, Subject: entry.Subject, NotAfter: entry.Expiry, Reason: entry.Reason}
+		if entry.Status == "R" {
+			record.RevokedAt = &entry.RevocationTime
+		}
+		if record.Status == "V" && entry.Expiry.Before(now) {
+			record.Status = "E"
+		}
+		if status == "" || status == record.Status {
+			records = append(records, record)
+		}
+	}
+	c.Ctx.Output.JSON(records, false, false)
+}
+
+// Revoke revokes an issued certificate for the reason query or form
+// parameter, unspecified by default, and rewrites the CRL.
+func (c CAController) Revoke() {
+	if !c.verified(true) {
+		return
+	}
+	cert, _, ok := c.serial()
+	if !ok {
+		return
+	}
+	reason := c.Ctx.Input.Query("reason")
+	if reason == "" {
+		reason = "unspecified"
+	}
+	if _, err := utils.RevocationReasonCode(reason); err != nil {
+		c.fail(http.StatusBadRequest, err)
+		return
+	}
+	serial := utils.FormatSerial(cert.SerialNumber)
+	if err := certAuthority.Revoke(cert.SerialNumber, reason); errors.Is(err, pki.ErrAlreadyRevoked) {
+		c.fail(http.StatusConflict, err)
+		return
+	} else if err != nil {
+		logger.Error("revoke %s failed, error %v", serial, err)
+		c.fail(http.StatusInternalServerError, err)
+		return
+	}
+	logger.Info("revoked serial %s of %s, reason %s, by %s", serial, cert.Subject.String(), reason, c.caller())
+	c.Ctx.Output.JSON(map[string]string{"serial": serial, "status": "R", "reason": reason}, false, false)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+	"github.com/beego/beego/v2/server/web"
+)
+
+// registerESTRoutes serves the CA of -ca-cert over Enrollment over Secure
+// Transport, RFC 7030, next to the /ca endpoints.
+func registerESTRoutes() {
+	web.BConfig.CopyRequestBody = true
+	web.CtrlGet("/.well-known/est/cacerts", ESTController.CACerts)
+	web.CtrlPost("/.well-known/est/simpleenroll", ESTController.SimpleEnroll)
+	web.CtrlPost("/.well-known/est/simplereenroll", ESTController.SimpleReenroll)
+}
+
+type ESTController struct {
+	CAController
+}
+
+// certsOnly answers with the certificates as a base64 PKCS#7 certs-only
+// message.
+func (c ESTController) certsOnly(certs ...*x509.Certificate) {
+	ders := make([][]byte, 0, len(certs))
+	for _, cert := range certs {
+		ders = append(ders, cert.Raw)
+	}
+	der, err := pki.EncodeCertsOnly(ders...)
+	if err != nil {
+		logger.Error("encode pkcs7 failed, error %v", err)
+		c.fail(http.StatusInternalServerError, err)
+		return
+	}
+	c.Ctx.Output.Header("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+	c.Ctx.Output.Header("Content-Transfer-Encoding", "base64")
+	c.Ctx.Output.Body([]byte(base64.StdEncoding.EncodeToString(der)))
+}
+
+// csr decodes the base64 DER PKCS#10 body of an enrollment into the PEM
+// SignServerCert expects.
+func (c ESTController) csr() ([]byte, bool) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(c.Ctx.Input.RequestBody)), ""))
+	if err != nil || len(der) == 0 {
+		c.fail(http.StatusBadRequest, errors.New("the body must hold a base64 encoded application/pkcs10 request"))
+		return nil, false
+	}
+	return pki.EncodeCSR(der), true
+}
+
+// CACerts returns the CA certificate and the certificates above it.
+func (c ESTController) CACerts() {
+	certs := []*x509.Certificate{certAuthority.Cert}
+	for _, block := range certAuthority.Chain {
+		cert, err := pki.ParseCertificate(block)
+		if err != nil {
+			c.fail(http.StatusInternalServerError, err)
+			return
+		}
+		certs = append(certs, cert)
+	}
+	c.certsOnly(certs...)
+}
+
+// SimpleEnroll signs the request of any client the server verified within
+// the policy of the CA. The profile query parameter picks tls-server, the
+// default, or tls-client, EST never issues CA or OCSP signer certificates.
+func (c ESTController) SimpleEnroll() {
+	if !c.verified(false) {
+		return
+	}
+	csrBlock, ok := c.csr()
+	if !ok {
+		return
+	}
+	opts := certAuthority.opts
+	switch opts.Profile = c.Ctx.Input.Query("profile"); opts.Profile {
+	case "":
+		opts.Profile = "tls-server"
+	case "tls-server", "tls-client":
+	default:
+		c.fail(http.StatusBadRequest, fmt.Errorf("est enrolls profile tls-server or tls-client, not %q", opts.Profile))
+		return
+	}
+	if err := certAuthority.checkEnrollment(c.Ctx.Request.TLS, csrBlock); err != nil {
+		logger.Warn("reject est enrollment from %s, error %v", c.caller(), err)
+		c.fail(http.StatusForbidden, err)
+		return
+	}
+	_, cert, err := certAuthority.Issue(rand.Reader, csrBlock, opts)
+	if err != nil {
+		logger.Warn("reject est enrollment from %s, error %v", c.caller(), err)
+		c.fail(http.StatusBadRequest, err)
+		return
+	}
+	logger.Info("est enrolled serial %s for %s to %s", utils.FormatSerial(cert.SerialNumber), cert.Subject.String(), c.caller())
+	c.certsOnly(cert)
+}
+
+// SimpleReenroll renews the client certificate the request was made with,
+// the request must repeat its subject and names.
+func (c ESTController) SimpleReenroll() {
+	if !c.verified(false) {
+		return
+	}
+	csrBlock, ok := c.csr()
+	if !ok {
+		return
+	}
+	current := c.Ctx.Request.TLS.VerifiedChains[0][0]
+	_, cert, err := certAuthority.Reenroll(rand.Reader, current, csrBlock, certAuthority.opts)
+	if err != nil {
+		logger.Warn("reject est re-enrollment from %s, error %v", c.caller(), err)
+		c.fail(http.StatusForbidden, err)
+		return
+	}
+	logger.Info("est re-enrolled serial %s as %s for %s", utils.FormatSerial(current.SerialNumber), utils.FormatSerial(cert.SerialNumber), cert.Subject.String())
+	c.certsOnly(cert)
+}
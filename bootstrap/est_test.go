@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+	"github.com/beego/beego/v2/server/web"
+)
+
+// TestESTReenrollGeneratedServerCert renews a serverN.crt as the generator
+// signs it, tls-peer without a profile, through simplereenroll of a CA
+// without a policy.
+func TestESTReenrollGeneratedServerCert(t *testing.T) {
+	dir := t.TempDir()
+	caKey, err := pki.GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBlock, err := pki.SignCACert(nil, caKey, "DevCAService1", pki.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBlock, err := utils.EncodePrivateKey(caKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertPath, caKeyPath := filepath.Join(dir, "ca1.crt"), filepath.Join(dir, "ca1.key")
+	if err := pki.WriteFile(caCertPath, caBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := pki.WriteFile(caKeyPath, keyBlock); err != nil {
+		t.Fatal(err)
+	}
+	authority, err := pki.LoadAuthority(caCertPath, "", caKeyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer authority.Close()
+
+	leaf := pki.CSROptions{
+		Subject: pkix.Name{CommonName: "DevelopService"},
+		SANs:    pki.SANs{DNSNames: []string{"DevelopService"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}},
+	}
+	serverKey, err := pki.GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCSR, err := pki.CreateCSR(nil, serverKey, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverBlock, err := pki.SignServerCert(nil, serverCSR, caBlock, caKey, pki.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverPath := filepath.Join(dir, "server1.crt")
+	if err := pki.WriteFile(serverPath, serverBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := pki.RecordIssuance(caCertPath, serverBlock, serverPath); err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := pki.ParseCertificate(serverBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile := pki.ProfileOf(serverCert); profile != "tls-peer" {
+		t.Fatalf("generated server cert has profile %q, want tls-peer", profile)
+	}
+
+	newKey, err := pki.GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewCSR, err := pki.CreateCSR(nil, newKey, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, _ := pem.Decode(renewCSR)
+
+	certAuthority = &caServer{Authority: authority}
+	defer func() { certAuthority = nil }()
+	registerESTRoutes()
+
+	req := httptest.NewRequest(http.MethodPost, "/.well-known/est/simplereenroll", strings.NewReader(base64.StdEncoding.EncodeToString(csrDER.Bytes)))
+	req.Header.Set("Content-Type", "application/pkcs10")
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{serverCert, authority.Cert}}}
+	recorder := httptest.NewRecorder()
+	web.BeeApp.Handlers.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("simplereenroll answered %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	der, err := base64.StdEncoding.DecodeString(recorder.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pki.ParseCertsOnly(der)
+	if err != nil || len(certs) != 1 {
+		t.Fatalf("parse certs-only answer failed, %d certs, error %v", len(certs), err)
+	}
+	renewed := certs[0]
+	if renewed.SerialNumber.Cmp(serverCert.SerialNumber) == 0 || renewed.Subject.String() != serverCert.Subject.String() {
+		t.Errorf("renewed cert %s of %q does not replace %s", utils.FormatSerial(renewed.SerialNumber), renewed.Subject.String(), utils.FormatSerial(serverCert.SerialNumber))
+	}
+	if profile := pki.ProfileOf(renewed); profile != "tls-peer" {
+		t.Errorf("renewed cert has profile %q, want tls-peer", profile)
+	}
+}
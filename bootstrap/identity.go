@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"example.com/lx/beego/dev/pki"
+	"github.com/beego/beego/v2/server/web"
+	"github.com/beego/beego/v2/server/web/context"
+)
+
+// callerIDKey holds the SPIFFE ID of the client in the request data.
+const callerIDKey = "spiffeID"
+
+// peerSPIFFEID is the SPIFFE ID of the verified client certificate.
+func peerSPIFFEID(state *tls.ConnectionState) (*url.URL, error) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, errors.New("no verified client certificate")
+	}
+	return pki.SPIFFEIDOf(state.VerifiedChains[0][0])
+}
+
+// identityFilter stores the SPIFFE ID of the caller for the controllers.
+// With a trust domain, callers without an ID in that domain get a 403.
+func identityFilter(trustDomain string) web.FilterFunc {
+	return func(ctx *context.Context) {
+		id, err := peerSPIFFEID(ctx.Request.TLS)
+		if err == nil && trustDomain != "" && !pki.InTrustDomain(id, trustDomain) {
+			err = errors.New(id.String() + " is outside the trust domain " + trustDomain)
+		}
+		if err != nil {
+			if trustDomain != "" {
+				logger.Warn("reject %s %s from %s, error %v", ctx.Request.Method, ctx.Request.URL.Path, ctx.Request.RemoteAddr, err)
+				ctx.Output.SetStatus(http.StatusForbidden)
+				ctx.Output.JSON(map[string]string{"error": err.Error()}, false, false)
+			}
+			return
+		}
+		ctx.Input.SetData(callerIDKey, id.String())
+	}
+}
+
+// CallerID is the SPIFFE ID of the client, empty when its certificate has
+// none.
+func (c ServerController) CallerID() string {
+	id, _ := c.Ctx.Input.GetData(callerIDKey).(string)
+	return id
+}
+
+func (c ServerController) WhoAmI() {
+	c.Ctx.Output.JSON(map[string]string{"spiffeID": c.CallerID()}, false, false)
+}
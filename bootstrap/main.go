@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"example.com/lx/beego/dev/utils"
 	"fmt"
 	"os"
@@ -30,6 +31,7 @@ func runCommand(name string, arg ...string) ([]byte, error) {
 }
 
 func (c ServerController) StartServer() {
+	logger.Info("start zk Server requested by %q", c.CallerID())
 	if resp, err := runCommand("/opt/zookeeper/bin/zkServer.sh", "start"); err != nil {
 		logger.Error("start zk Server failed, error %v", err)
 		c.Ctx.Output.Body(resp)
@@ -39,6 +41,7 @@ func (c ServerController) StartServer() {
 }
 
 func (c ServerController) StopServer() {
+	logger.Info("stop zk Server requested by %q", c.CallerID())
 	if resp, err := runCommand("/opt/zookeeper/bin/zkServer.sh", "stop"); err != nil {
 		logger.Error("stop zk Server failed, error %v", err)
 		c.Ctx.Output.Body(resp)
@@ -59,21 +62,37 @@ func main() {
 		//        return
 	} else if os.Args[1] == "https" {
 		web.BConfig.Listen.EnableHTTP = false
-		if len(os.Args) == 3 {
+		// the server modes verify client certificates, app.conf leaves
+		// mutual https off
+		web.BConfig.Listen.EnableMutualHTTPS = true
+		if len(os.Args) >= 3 {
 			certConfig := utils.CertConfig{
 				ServerCert: fmt.Sprintf("conf/cert%s/server%s.crt", os.Args[2], os.Args[2]),
 				ServerKey:  fmt.Sprintf("conf/cert%s/server%s.key", os.Args[2], os.Args[2]),
 				CaCert:     fmt.Sprintf("conf/cert%s/ca.crt", os.Args[2]),
 			}
-			web.BConfig.Listen.HTTPSCertFile = certConfig.ServerCert
-			web.BConfig.Listen.HTTPSKeyFile = certConfig.ServerKey
-			web.BConfig.Listen.TrustCaFile = certConfig.CaCert
+			caFlags := applyServerFlags(&certConfig, os.Args[3:])
+			if err := configureTLS(certConfig); err != nil {
+				logger.Error("configure tls failed, error %v", err)
+				return
+			}
+			var err error
+			if certAuthority, err = caFlags.load(); err != nil {
+				logger.Error("load ca failed, error %v", err)
+				return
+			}
 			web.BConfig.Listen.HTTPSAddr = "192.168.0.104"
+			web.InsertFilter("/*", web.BeforeRouter, identityFilter(certConfig.TrustDomain))
 		}
 		logger.Info("start server")
 		web.CtrlGet("/server/health", ServerController.HealthCheck)
+		web.CtrlGet("/server/whoami", ServerController.WhoAmI)
 		web.CtrlPost("/server/start", ServerController.StartServer)
 		web.CtrlPost("/server/stop", ServerController.StopServer)
+		if certAuthority != nil {
+			registerCARoutes()
+			registerESTRoutes()
+		}
 		logger.Info("server handlers %v", web.PrintTree())
 		web.Run()
 	} else if os.Args[1] == "client" {
@@ -83,24 +102,39 @@ func main() {
 			ServerKey:  fmt.Sprintf("conf/cert%s/server%s.key", os.Args[2], os.Args[2]),
 			CaCert:     fmt.Sprintf("conf/cert%s/ca.crt", os.Args[2]),
 		}
+		applyServerFlags(certConfig, os.Args[3:])
 		utils.GetRequest(url, certConfig)
 	} else if os.Args[1] == "httpsdev" {
 		web.BConfig.Listen.EnableHTTP = false
+		web.BConfig.Listen.EnableMutualHTTPS = true
 		//        if len(os.Args) == 3 {
 		certConfig := utils.CertConfig{
 			ServerCert: fmt.Sprintf("conf/certs/server%s.crt", os.Args[2]),
 			ServerKey:  fmt.Sprintf("conf/certs/server%s.key", os.Args[2]),
 			CaCert:     fmt.Sprintf("conf/certs/ca%s.crt", os.Args[2]),
 		}
-		web.BConfig.Listen.HTTPSCertFile = certConfig.ServerCert
-		web.BConfig.Listen.HTTPSKeyFile = certConfig.ServerKey
-		web.BConfig.Listen.TrustCaFile = certConfig.CaCert
+		caFlags := applyServerFlags(&certConfig, os.Args[3:])
+		if err := configureTLS(certConfig); err != nil {
+			logger.Error("configure tls failed, error %v", err)
+			return
+		}
+		var err error
+		if certAuthority, err = caFlags.load(); err != nil {
+			logger.Error("load ca failed, error %v", err)
+			return
+		}
 		web.BConfig.Listen.HTTPSAddr = "127.0.0.1"
 		//        }
+		web.InsertFilter("/*", web.BeforeRouter, identityFilter(certConfig.TrustDomain))
 		logger.Info("start server")
 		web.CtrlGet("/server/health", ServerController.HealthCheck)
+		web.CtrlGet("/server/whoami", ServerController.WhoAmI)
 		web.CtrlPost("/server/start", ServerController.StartServer)
 		web.CtrlPost("/server/stop", ServerController.StopServer)
+		if certAuthority != nil {
+			registerCARoutes()
+			registerESTRoutes()
+		}
 		logger.Info("server handlers %v", web.PrintTree())
 		web.Run()
 	} else if os.Args[1] == "clientdev" {
@@ -110,6 +144,51 @@ func main() {
 			ServerKey:  fmt.Sprintf("conf/certs/client%s.key", os.Args[2]),
 			CaCert:     fmt.Sprintf("conf/certs/ca%s.crt", os.Args[2]),
 		}
+		applyServerFlags(certConfig, os.Args[3:])
 		utils.GetRequest(url, certConfig)
+	} else if os.Args[1] == "acmedev" {
+		// httpsdev with the ca of -ca-cert, ca<N>.crt by default, served to
+		// ACME clients, which have no client certificate
+		web.BConfig.Listen.EnableHTTP = false
+		web.BConfig.Listen.EnableMutualHTTPS = true
+		web.BConfig.Listen.ClientAuth = int(tls.VerifyClientCertIfGiven)
+		certConfig := utils.CertConfig{
+			ServerCert: fmt.Sprintf("conf/certs/server%s.crt", os.Args[2]),
+			ServerKey:  fmt.Sprintf("conf/certs/server%s.key", os.Args[2]),
+			CaCert:     fmt.Sprintf("conf/certs/ca%s.crt", os.Args[2]),
+		}
+		caFlags := applyServerFlags(&certConfig, os.Args[3:])
+		if *caFlags.cert == "" {
+			*caFlags.cert = certConfig.CaCert
+		}
+		if err := configureTLS(certConfig); err != nil {
+			logger.Error("configure tls failed, error %v", err)
+			return
+		}
+		var err error
+		if certAuthority, err = caFlags.load(); err != nil {
+			logger.Error("load ca failed, error %v", err)
+			return
+		}
+		web.BConfig.Listen.HTTPSAddr = "127.0.0.1"
+		web.InsertFilter("/*", web.BeforeRouter, identityFilter(certConfig.TrustDomain))
+		web.Handler("/acme", caFlags.acmeServer(certAuthority), true)
+		registerCARoutes()
+		registerESTRoutes()
+		logger.Info("server handlers %v", web.PrintTree())
+		web.Run()
+	} else if os.Args[1] == "ocspdev" {
+		// plain http on httpport, revocation clients have no client cert
+		responder, err := ocspResponder(os.Args[2:])
+		if err != nil {
+			logger.Error("load ocsp responder failed, error %v", err)
+			return
+		}
+		web.BConfig.Listen.EnableHTTP = true
+		web.BConfig.Listen.EnableHTTPS = false
+		web.BConfig.Listen.EnableMutualHTTPS = false
+		web.Handler("/ocsp", responder, true)
+		logger.Info("server handlers %v", web.PrintTree())
+		web.Run()
 	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+// ocspResponder loads the CAs named by -ca, by default every CA under
+// conf/certs that has a delegated signer written by the ocsp-signer command
+// or a topology ocspURL.
+func ocspResponder(args []string) (*utils.OCSPResponder, error) {
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	caFiles := flags.String("ca", "", "comma separated CA certificates to answer for, each with <ca>.ocsp.crt and <ca>.ocsp.key next to it")
+	password := flags.String("key-password", "", "password of the encrypted signer keys")
+	passwordFile := flags.String("key-password-file", "", "file holding the password of the encrypted signer keys")
+	validity := flags.Duration("validity", time.Hour, "time until the next update announced in responses")
+	flags.Parse(args)
+	resolved, err := utils.ResolvePassword(*password, *passwordFile, "SERVER_KEY_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	var caCerts []string
+	if *caFiles != "" {
+		caCerts = strings.Split(*caFiles, ",")
+	} else {
+		signers, _ := filepath.Glob("conf/certs/*.ocsp.crt")
+		for _, signer := range signers {
+			caCerts = append(caCerts, strings.TrimSuffix(signer, ".ocsp.crt")+".crt")
+		}
+	}
+	if len(caCerts) == 0 {
+		return nil, fmt.Errorf("no ca with an ocsp signer found, use -ca")
+	}
+	responder := &utils.OCSPResponder{Validity: *validity}
+	for _, caCert := range caCerts {
+		authority, err := utils.LoadOCSPAuthority(caCert, resolved)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("answer ocsp requests for %s", authority.Cert.Subject.CommonName)
+		responder.Authorities = append(responder.Authorities, authority)
+	}
+	return responder, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+	"github.com/beego/beego/v2/server/web"
+)
+
+// applyServerFlags parses the flags that follow the mode arguments into
+// certConfig: the password of an encrypted key from -key-password or
+// -key-password-file, falling back to the SERVER_KEY_PASSWORD environment
+// variable, the CRL and OCSP sources used to check peer certificates and
+// the SPIFFE trust domain of the peers. The flags of the CA endpoints are
+// returned for the server modes to load.
+// Without -crl the CRL written next to the CA certificate is used if present.
+func applyServerFlags(certConfig *utils.CertConfig, args []string) *caServerFlags {
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	password := flags.String("key-password", "", "password of the encrypted private key")
+	passwordFile := flags.String("key-password-file", "", "file holding the password of the encrypted private key")
+	crlFiles := flags.String("crl", "", "comma separated CRL files, PEM or DER, checked against peer certificates")
+	crlReload := flags.Duration("crl-reload", 5*time.Minute, "interval to reread the CRL files")
+	ocspURL := flags.String("ocsp", "", "URL of an OCSP responder asked about peer certificates")
+	ocspRequired := flags.Bool("ocsp-required", false, "reject peers when the OCSP responder gives no answer")
+	trustDomain := flags.String("trust-domain", "", "only accept peers whose certificate carries a spiffe:// ID in this trust domain")
+	caFlags := registerCAServerFlags(flags)
+	flags.Parse(args)
+	resolved, err := utils.ResolvePassword(*password, *passwordFile, "SERVER_KEY_PASSWORD")
+	if err != nil {
+		logger.Error("resolve key password failed, error %v", err)
+	}
+	certConfig.ServerPassword = resolved
+	if *crlFiles != "" {
+		certConfig.CRLFiles = strings.Split(*crlFiles, ",")
+	} else if crlFile := strings.TrimSuffix(certConfig.CaCert, filepath.Ext(certConfig.CaCert)) + ".crl.pem"; fileExists(crlFile) {
+		certConfig.CRLFiles = []string{crlFile}
+	}
+	certConfig.CRLReload = *crlReload
+	certConfig.OCSPURL = *ocspURL
+	certConfig.OCSPRequired = *ocspRequired
+	certConfig.TrustDomain = *trustDomain
+	return caFlags
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// configureTLS loads the server key pair here instead of handing beego the
+// file names, so encrypted keys can be decrypted with ServerPassword first.
+func configureTLS(certConfig utils.CertConfig) error {
+	certificate, err := utils.LoadX509KeyPair(certConfig.ServerCert, certConfig.ServerKey, certConfig.ServerPassword)
+	if err != nil {
+		return fmt.Errorf("load server cert %q, key %q failed, error %v", certConfig.ServerCert, certConfig.ServerKey, err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if web.BConfig.Listen.EnableMutualHTTPS {
+		caCert, err := os.ReadFile(certConfig.CaCert)
+		if err != nil {
+			return fmt.Errorf("read ca cert file %q failed, error %v", certConfig.CaCert, err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.ClientAuthType(web.BConfig.Listen.ClientAuth)
+		checker, err := certConfig.RevocationChecker()
+		if err != nil {
+			return fmt.Errorf("load revocation lists failed, error %v", err)
+		}
+		// runs after chain verification, so revoked peers fail the
+		// handshake before any handler sees the request.
+		tlsConfig.VerifyPeerCertificate = checker.VerifyPeerCertificate
+		if len(certConfig.CRLFiles) > 0 && certConfig.CRLReload > 0 {
+			go checker.ReloadEvery(certConfig.CRLReload, nil)
+		}
+		logger.Info("check client certificates against crl %v, ocsp %q", certConfig.CRLFiles, certConfig.OCSPURL)
+		// beego replaces Server.TLSConfig in mutual mode, serve it as plain
+		// https with the client verification configured here instead.
+		web.BConfig.Listen.EnableMutualHTTPS = false
+		web.BConfig.Listen.EnableHTTPS = true
+	}
+	web.BConfig.Listen.HTTPSCertFile = ""
+	web.BConfig.Listen.HTTPSKeyFile = ""
+	web.BConfig.Listen.TrustCaFile = certConfig.CaCert
+	web.BeeApp.Server.TLSConfig = tlsConfig
+	return nil
+}
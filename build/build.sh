@@ -2,9 +2,9 @@
 
 set -x
 echo "start to build images"
-cp -r ../go.mod ../go.sum ../cmd ../conf .
+cp -r ../go.mod ../go.sum ../cmd ../utils ../pki ../acme ../bootstrap ../conf .
 docker build -t zkserver .
-rm -rf ./go.mod ./go.sum ./cmd ./conf
+rm -rf ./go.mod ./go.sum ./cmd ./utils ./pki ./acme ./bootstrap ./conf
 echo "build succeed"
 set +x
 
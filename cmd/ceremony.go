@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+)
+
+// readPasswordFiles resolves the password in each of files.
+func readPasswordFiles(files []string) ([]string, error) {
+	passwords := make([]string, len(files))
+	for i, file := range files {
+		password, err := utils.ResolvePassword("", file, "")
+		if err != nil {
+			return nil, err
+		}
+		passwords[i] = password
+	}
+	return passwords, nil
+}
+
+// splitCAKey writes the key at keyPath as total shares to
+// <prefix>.share<i>.pem, each sealed with its password, and recombines
+// every share from the files before returning, so a bad write is noticed
+// while the key still exists.
+func splitCAKey(keyPath, keyPassword, prefix string, threshold, total int, passwords []string) ([]string, error) {
+	if len(passwords) > 1 && len(passwords) != total {
+		return nil, fmt.Errorf("got %d share passwords for %d shares, want none, one or one per share", len(passwords), total)
+	}
+	key, err := utils.ReadPrivateKey(keyPath, keyPassword)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := pki.SplitKey(rand.Reader, key, threshold, total)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, total)
+	for i, share := range shares {
+		password := ""
+		if len(passwords) == 1 {
+			password = passwords[0]
+		} else if len(passwords) > 1 {
+			password = passwords[i]
+		}
+		content, err := pki.EncodeKeyShare(rand.Reader, share, password)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = fmt.Sprintf("%s.share%d.pem", prefix, share.Index)
+		if err := writeFile(paths[i], content); err != nil {
+			return nil, err
+		}
+	}
+	// consecutive windows of threshold shares cover every share
+	for start := 0; start < total; start += threshold {
+		if start+threshold > total {
+			start = total - threshold
+		}
+		window := paths[start : start+threshold]
+		var windowPasswords []string
+		if len(passwords) > 1 {
+			windowPasswords = passwords[start : start+threshold]
+		} else {
+			windowPasswords = passwords
+		}
+		// CombineKey checks the result against the key id of key
+		signer, err := pki.OpenShareSigner(window, windowPasswords)
+		if err != nil {
+			return nil, fmt.Errorf("recombine %v failed, error %v", window, err)
+		}
+		signer.Close()
+	}
+	return paths, nil
+}
+
+func runCeremony(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("usage: ceremony split|combine [flags]")
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("ceremony "+action, flag.ExitOnError)
+	switch action {
+	case "split":
+		keyPath := flags.String("key", "", "PEM private key of the CA to split")
+		keyPassword := flags.String("key-password", "", "password of an encrypted key")
+		keyPasswordFile := flags.String("key-password-file", "", "file holding the password of an encrypted key")
+		threshold := flags.Int("threshold", 3, "shares needed to recombine the key, M")
+		total := flags.Int("shares", 5, "shares to write, N")
+		out := flags.String("out", "", "shares are written to <out>.share<i>.pem, defaults to the key path without .key")
+		var passwordFiles stringsFlag
+		flags.Var(&passwordFiles, "share-password-file", "file holding the password of the shares, repeat once per share for a password per operator")
+		removeKey := flags.Bool("remove-key", true, "delete the key once every share recombines it, -remove-key=false leaves the ceremony unfinished")
+		flags.Parse(args[1:])
+		if *keyPath == "" {
+			log.Fatalf("-key is required")
+		}
+		if *out == "" {
+			*out = strings.TrimSuffix(*keyPath, ".key")
+		}
+		password, err := utils.ResolvePassword(*keyPassword, *keyPasswordFile, "CERT_KEY_PASSWORD")
+		if err != nil {
+			log.Fatalf("resolve key password failed, error %v", err)
+		}
+		passwords, err := readPasswordFiles(passwordFiles)
+		if err != nil {
+			log.Fatalf("resolve share password failed, error %v", err)
+		}
+		paths, err := splitCAKey(*keyPath, password, *out, *threshold, *total, passwords)
+		if err != nil {
+			log.Fatalf("split %s failed, error %v", *keyPath, err)
+		}
+		log.Printf("split %s into %d-of-%d shares %s", *keyPath, *threshold, *total, strings.Join(paths, ", "))
+		if *removeKey {
+			if err := os.Remove(*keyPath); err != nil {
+				log.Fatalf("remove %s failed, error %v", *keyPath, err)
+			}
+			log.Printf("removed %s, sign with -key-share from now on", *keyPath)
+		} else {
+			log.Printf("kept %s, the ceremony is not finished while the key exists, delete it once the shares are handed out", *keyPath)
+		}
+	case "combine":
+		var shares, passwordFiles stringsFlag
+		flags.Var(&shares, "share", "share file, repeatable, at least the threshold of them")
+		flags.Var(&passwordFiles, "share-password-file", "file holding the password of the shares, repeat once per -share for a password per operator")
+		caCertPath := flags.String("ca-cert", "", "check that the shares recombine the key of this CA")
+		out := flags.String("out", "", "where to write the recombined PKCS#8 key, needs -write-key")
+		writeKey := flags.Bool("write-key", false, "write the recombined key to -out, without it combine only checks the shares against -ca-cert")
+		keyPassword := flags.String("key-password", "", "encrypt the written key with this password")
+		keyPasswordFile := flags.String("key-password-file", "", "file holding the password to encrypt the written key with")
+		flags.Parse(args[1:])
+		if len(shares) == 0 {
+			log.Fatalf("-share is required")
+		}
+		if *writeKey && *out == "" {
+			log.Fatalf("-write-key needs -out")
+		}
+		if !*writeKey && *out != "" {
+			log.Fatalf("-out writes the recombined key to disk, add -write-key to confirm")
+		}
+		if !*writeKey && *caCertPath == "" {
+			log.Fatalf("-ca-cert is required to check the shares")
+		}
+		passwords, err := readPasswordFiles(passwordFiles)
+		if err != nil {
+			log.Fatalf("resolve share password failed, error %v", err)
+		}
+		keyShares, err := pki.ReadKeyShares(shares, passwords)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		signer, err := pki.CombineKey(keyShares)
+		if err != nil {
+			log.Fatalf("recombine key failed, error %v", err)
+		}
+		if *caCertPath != "" {
+			if _, err := pki.NewAuthority(*caCertPath, signer); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+		if !*writeKey {
+			log.Printf("%d shares recombine the key of %s", len(shares), *caCertPath)
+			return
+		}
+		password, err := utils.ResolvePassword(*keyPassword, *keyPasswordFile, "CERT_KEY_PASSWORD")
+		if err != nil {
+			log.Fatalf("resolve key password failed, error %v", err)
+		}
+		keyBlock, err := utils.EncodePrivateKey(signer, password)
+		if err != nil {
+			log.Fatalf("encode key failed, error %v", err)
+		}
+		if err := writeFile(*out, keyBlock); err != nil {
+			log.Fatalf("write key failed, error %v", err)
+		}
+		log.Printf("recombined %d shares into %s", len(shares), *out)
+	default:
+		log.Fatalf("unknown ceremony action %q, want split or combine", action)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"example.com/lx/beego/dev/pki"
+)
+
+func TestSplitCAKey(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{OutputDir: dir, KeyAlgorithm: "ecdsa-p256", CAs: []CASpec{{Name: "ca0"}}}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	caCertPath, caKeyPath := filepath.Join(dir, "ca0.crt"), filepath.Join(dir, "ca0.key")
+	prefix := filepath.Join(dir, "ceremony", "ca0")
+	paths, err := splitCAKey(caKeyPath, "", prefix, 3, 5, []string{"secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 5 || paths[4] != prefix+".share5.pem" {
+		t.Fatalf("wrote shares %v", paths)
+	}
+	signer, err := pki.OpenShareSigner([]string{paths[4], paths[1], paths[3]}, []string{"secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pki.NewAuthority(caCertPath, signer); err != nil {
+		t.Errorf("shares do not recombine the key of ca0, error %v", err)
+	}
+	if _, err := pki.OpenShareSigner(paths[:2], []string{"secret"}); err == nil {
+		t.Error("expected two of a 3-of-5 split to be rejected")
+	}
+
+	operators := []string{"alice", "bob", "carol"}
+	paths, err = splitCAKey(caKeyPath, "", prefix, 2, 3, operators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pki.OpenShareSigner([]string{paths[0], paths[2]}, []string{"alice", "carol"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := pki.OpenShareSigner([]string{paths[0], paths[2]}, []string{"alice", "bob"}); err == nil {
+		t.Error("expected the wrong operator password to be rejected")
+	}
+	if _, err := splitCAKey(caKeyPath, "", prefix, 2, 3, operators[:2]); err == nil {
+		t.Error("expected two passwords for three shares to be rejected")
+	}
+}
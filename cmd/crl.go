@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+)
+
+// loadCA reads a CA certificate and opens its private key with the key
+// backend, chosen from caKey when empty.
+func loadCA(caCertPath, keyBackend, caKey, keyPassword string) (*x509.Certificate, *issuer, error) {
+	authority, err := pki.LoadAuthority(caCertPath, keyBackend, caKey, keyPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+	return authority.Cert, authorityIssuer(authority), nil
+}
+
+func authorityIssuer(authority *pki.Authority) *issuer {
+	return &issuer{privateKey: authority.Signer, certBlock: authority.CertBlock, chain: authority.Chain}
+}
+
+// caKeyFlags name a CA certificate and how to open its key, or the key
+// shares to recombine it from.
+type caKeyFlags struct {
+	cert, key, keyBackend, keyPassword, keyPasswordFile *string
+	shares, sharePasswordFiles                          stringsFlag
+}
+
+func caFlags(flags *flag.FlagSet) *caKeyFlags {
+	f := &caKeyFlags{
+		cert:            flags.String("ca-cert", "", "PEM certificate of the CA"),
+		key:             flags.String("ca-key", "", "private key of the CA, a PEM file or a pkcs11: URI, defaults to the certificate path with .key"),
+		keyBackend:      flags.String("key-backend", "", "key backend of -ca-key, one of "+strings.Join(pki.KeyBackends, ", ")+", chosen from -ca-key when empty"),
+		keyPassword:     flags.String("key-password", "", "password of an encrypted CA key or PIN of a pkcs11 token"),
+		keyPasswordFile: flags.String("key-password-file", "", "file holding the password of an encrypted CA key or PIN of a pkcs11 token"),
+	}
+	flags.Var(&f.shares, "key-share", "share of a CA key split by the ceremony command, repeat until the threshold, instead of -ca-key")
+	flags.Var(&f.sharePasswordFiles, "key-share-password-file", "file holding the password of the key shares, repeat once per -key-share for a password per operator")
+	return f
+}
+
+func (f *caKeyFlags) password() string {
+	keyPassword, err := utils.ResolvePassword(*f.keyPassword, *f.keyPasswordFile, "CERT_KEY_PASSWORD")
+	if err != nil {
+		log.Fatalf("resolve key password failed, error %v", err)
+	}
+	return keyPassword
+}
+
+func (f *caKeyFlags) load() (*x509.Certificate, *issuer) {
+	if *f.cert == "" {
+		log.Fatalf("-ca-cert is required")
+	}
+	if len(f.shares) > 0 {
+		passwords, err := readPasswordFiles(f.sharePasswordFiles)
+		if err != nil {
+			log.Fatalf("resolve share password failed, error %v", err)
+		}
+		signer, err := pki.OpenShareSigner(f.shares, passwords)
+		if err != nil {
+			log.Fatalf("recombine ca key failed, error %v", err)
+		}
+		authority, err := pki.NewAuthority(*f.cert, signer)
+		if err != nil {
+			log.Fatalf("load ca failed, error %v", err)
+		}
+		return authority.Cert, authorityIssuer(authority)
+	}
+	caKey := *f.key
+	if caKey == "" {
+		caKey = utils.CAPrefix(*f.cert) + ".key"
+	}
+	caCert, ca, err := loadCA(*f.cert, *f.keyBackend, caKey, f.password())
+	if err != nil {
+		log.Fatalf("load ca failed, error %v", err)
+	}
+	return caCert, ca
+}
+
+func runRevoke(args []string) {
+	flags := flag.NewFlagSet("revoke", flag.ExitOnError)
+	caFlag := caFlags(flags)
+	caCertPath := caFlag.cert
+	certPath := flags.String("cert", "", "PEM certificate to revoke")
+	serialValue := flags.String("serial", "", "hexadecimal serial number to revoke, instead of -cert")
+	reason := flags.String("reason", "unspecified", "revocation reason, e.g. keyCompromise, superseded, cessationOfOperation")
+	crlDays := flags.Int("crl-days", 30, "days until the next CRL update")
+	flags.Parse(args)
+	caCert, ca := caFlag.load()
+
+	var cert *x509.Certificate
+	var serial *big.Int
+	var err error
+	switch {
+	case *certPath != "":
+		if cert, err = readCertFile(*certPath); err != nil {
+			log.Fatalf("read certificate failed, error %v", err)
+		}
+		if err := cert.CheckSignatureFrom(caCert); err != nil {
+			log.Fatalf("certificate %q was not issued by %q, error %v", *certPath, *caCertPath, err)
+		}
+		serial = cert.SerialNumber
+	case *serialValue != "":
+		if serial, err = utils.ParseSerial(*serialValue); err != nil {
+			log.Fatalf("parse serial failed, error %v", err)
+		}
+	default:
+		log.Fatalf("either -cert or -serial is required")
+	}
+	if err := pki.RevokeCertificate(*caCertPath, caCert, serial, cert, *reason, time.Now()); err != nil {
+		log.Fatalf("revoke %s failed, error %v", utils.FormatSerial(serial), err)
+	}
+	log.Printf("revoked serial %s of %s, reason %s", utils.FormatSerial(serial), caCert.Subject.CommonName, *reason)
+	if err := pki.WriteCRL(*caCertPath, caCert, ca.privateKey, *crlDays); err != nil {
+		log.Fatalf("write crl failed, error %v", err)
+	}
+}
+
+func runCRL(args []string) {
+	flags := flag.NewFlagSet("crl", flag.ExitOnError)
+	caFlag := caFlags(flags)
+	caCertPath := caFlag.cert
+	crlDays := flags.Int("crl-days", 30, "days until the next CRL update")
+	flags.Parse(args)
+	caCert, ca := caFlag.load()
+	if err := pki.WriteCRL(*caCertPath, caCert, ca.privateKey, *crlDays); err != nil {
+		log.Fatalf("write crl failed, error %v", err)
+	}
+	log.Printf("wrote crl of %s to %s.crl", caCert.Subject.CommonName, utils.CAPrefix(*caCertPath))
+}
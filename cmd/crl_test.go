@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+)
+
+func TestRevokeAndWriteCRL(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p256",
+		CAs:          []CASpec{{Name: "ca1", CRLDistributionPoint: "http://127.0.0.1/ca1.crl"}},
+		Leaves:       []LeafSpec{{Name: "server1", Issuer: "ca1"}},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	caCertPath := filepath.Join(dir, "ca1.crt")
+	caCert, ca, err := loadCA(caCertPath, "", filepath.Join(dir, "ca1.key"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := readCert(t, filepath.Join(dir, "server1.crt"))
+	if len(leaf.CRLDistributionPoints) != 1 || leaf.CRLDistributionPoints[0] != "http://127.0.0.1/ca1.crl" {
+		t.Errorf("leaf crl distribution points are %v", leaf.CRLDistributionPoints)
+	}
+	if err := pki.RevokeCertificate(caCertPath, caCert, leaf.SerialNumber, leaf, "keyCompromise", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := pki.RevokeCertificate(caCertPath, caCert, leaf.SerialNumber, leaf, "keyCompromise", time.Now()); err == nil {
+		t.Error("expected an error revoking the same serial twice")
+	}
+	if err := pki.WriteCRL(caCertPath, caCert, ca.privateKey, 7); err != nil {
+		t.Fatal(err)
+	}
+	der, err := os.ReadFile(filepath.Join(dir, "ca1.crl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("crl signature check failed, error %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Fatalf("crl entries are %v, want the leaf serial", crl.RevokedCertificateEntries)
+	}
+	if crl.RevokedCertificateEntries[0].ReasonCode != 1 {
+		t.Errorf("reason code is %d, want keyCompromise", crl.RevokedCertificateEntries[0].ReasonCode)
+	}
+	if days := crl.NextUpdate.Sub(crl.ThisUpdate).Hours() / 24; days != 7 {
+		t.Errorf("next update is %v days after this update, want 7", days)
+	}
+}
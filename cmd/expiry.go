@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+)
+
+// ExpiringCert is a certificate found by scanExpiring that lapses within the
+// threshold, or already has.
+type ExpiringCert struct {
+	File     string            `json:"file"`
+	Subject  string            `json:"subject"`
+	Issuer   string            `json:"issuer"`
+	NotAfter time.Time         `json:"notAfter"`
+	DaysLeft int               `json:"daysLeft"`
+	IsCA     bool              `json:"isCA"`
+	Cert     *x509.Certificate `json:"-"`
+}
+
+// scanExpiring reads every *.crt in the directories matched by the dir
+// globs and returns those expiring before now+within, soonest first. Files
+// that hold no certificate are logged and skipped.
+func scanExpiring(dirGlobs []string, within time.Duration, now time.Time) ([]ExpiringCert, error) {
+	seen := map[string]bool{}
+	var expiring []ExpiringCert
+	for _, dirGlob := range dirGlobs {
+		dirs, err := filepath.Glob(dirGlob)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			paths, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				if seen[path] {
+					continue
+				}
+				seen[path] = true
+				cert, err := readCertFile(path)
+				if err != nil {
+					log.Printf("skip %s, error %v", path, err)
+					continue
+				}
+				if !cert.NotAfter.Before(now.Add(within)) {
+					continue
+				}
+				expiring = append(expiring, ExpiringCert{
+					File:     path,
+					Subject:  cert.Subject.String(),
+					Issuer:   cert.Issuer.String(),
+					NotAfter: cert.NotAfter,
+					DaysLeft: int(cert.NotAfter.Sub(now).Hours() / 24),
+					IsCA:     cert.IsCA,
+					Cert:     cert,
+				})
+			}
+		}
+	}
+	sort.SliceStable(expiring, func(i, j int) bool {
+		return expiring[i].NotAfter.Before(expiring[j].NotAfter)
+	})
+	return expiring, nil
+}
+
+func writeExpiring(w io.Writer, expiring []ExpiringCert, format string) error {
+	switch format {
+	case "", "table":
+		table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "FILE\tKIND\tNOT AFTER\tDAYS LEFT\tSUBJECT\tISSUER")
+		for _, e := range expiring {
+			kind := "leaf"
+			if e.IsCA {
+				kind = "ca"
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%s\n", e.File, kind, e.NotAfter.Format(time.RFC3339), e.DaysLeft, e.Subject, e.Issuer)
+		}
+		return table.Flush()
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if expiring == nil {
+			expiring = []ExpiringCert{}
+		}
+		return encoder.Encode(expiring)
+	default:
+		return fmt.Errorf("unsupported format %q, want table or json", format)
+	}
+}
+
+// findIssuer looks next to certPath for the CA certificate and key that
+// signed cert. Cross certificates share the subject of a CA but have no key
+// of their own, so they are skipped.
+func findIssuer(certPath string, cert *x509.Certificate, keyPassword string) (string, *issuer, error) {
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(certPath), "*.crt"))
+	if err != nil {
+		return "", nil, err
+	}
+	for _, path := range paths {
+		caKeyPath := utils.CAPrefix(path) + ".key"
+		if path == certPath || !fileExists(caKeyPath) {
+			continue
+		}
+		caCert, err := readCertFile(path)
+		if err != nil || !caCert.IsCA || string(caCert.RawSubject) != string(cert.RawIssuer) {
+			continue
+		}
+		if err := cert.CheckSignatureFrom(caCert); err != nil {
+			continue
+		}
+		_, ca, err := loadCA(path, "", caKeyPath, keyPassword)
+		if err != nil {
+			return "", nil, err
+		}
+		return path, ca, nil
+	}
+	return "", nil, fmt.Errorf("no ca certificate with a key found for issuer %q next to %q", cert.Issuer.String(), certPath)
+}
+
+// RenewOptions controls how renewCert replaces a certificate.
+type RenewOptions struct {
+	// RotateKey generates a new key of the same algorithm instead of
+	// certifying the existing one again.
+	RotateKey bool
+	// ValidityDays of the new certificate, 0 keeps the original period.
+	ValidityDays   int
+	KeyPassword    string
+	PKCS12Password string
+}
+
+// renewCert re-issues the leaf at certPath from its original CA with the
+// same subject, SANs, profile, distribution points and validity period. The
+// new certificate replaces the old file, a PKCS#12 bundle next to it is
+// rewritten too.
+func renewCert(certPath string, cert *x509.Certificate, opts RenewOptions) (*x509.Certificate, error) {
+	if cert.IsCA {
+		return nil, fmt.Errorf("%q is a ca certificate, regenerate it with its topology", certPath)
+	}
+	caCertPath, ca, err := findIssuer(certPath, cert, opts.KeyPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyPath := utils.CAPrefix(certPath) + ".key"
+	var privateKey crypto.Signer
+	var privateKeyBlock []byte
+	if opts.RotateKey {
+		algorithm, err := pki.KeyAlgorithmOf(cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if privateKey, privateKeyBlock, err = pki.GeneratePrivateKey(rand.Reader, algorithm, opts.KeyPassword); err != nil {
+			return nil, err
+		}
+	} else if privateKey, err = utils.ReadPrivateKey(keyPath, opts.KeyPassword); err != nil {
+		return nil, fmt.Errorf("%v, rotate the key instead", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		RawSubject:         cert.RawSubject,
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        cert.IPAddresses,
+		EmailAddresses:     cert.EmailAddresses,
+		URIs:               cert.URIs,
+		SignatureAlgorithm: pki.SignatureAlgorithm(privateKey),
+	}, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("create renewal csr failed, error %v", err)
+	}
+	validityDays := opts.ValidityDays
+	if validityDays == 0 {
+		validityDays = int(cert.NotAfter.Sub(cert.NotBefore).Hours()/24 + 0.5)
+	}
+	certOpts := pki.CertOptions{
+		ValidityDays:          validityDays,
+		Profile:               pki.ProfileOf(cert),
+		CRLDistributionPoints: cert.CRLDistributionPoints,
+		OCSPServers:           cert.OCSPServer,
+	}
+	certBlock, err := pki.SignServerCert(rand.Reader, pki.EncodeCSR(csrDER), ca.certBlock, ca.privateKey, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	renewed, err := pki.ParseCertificate(certBlock)
+	if err != nil {
+		return nil, err
+	}
+	paths, contents := []string{certPath}, [][]byte{certBlock}
+	if privateKeyBlock != nil {
+		paths, contents = append(paths, keyPath), append(contents, privateKeyBlock)
+	}
+	if err := replaceFiles(paths, contents); err != nil {
+		return nil, err
+	}
+	if err := pki.RecordIssuance(caCertPath, certBlock, certPath); err != nil {
+		return nil, err
+	}
+	if p12Path := utils.CAPrefix(certPath) + ".p12"; fileExists(p12Path) {
+		if err := writePKCS12(p12Path, privateKey, certBlock, [][]byte{ca.certBlock}, opts.PKCS12Password); err != nil {
+			return nil, err
+		}
+	}
+	return renewed, nil
+}
+
+// replaceFiles writes each content to a temporary file next to its path and
+// renames them over the paths only once all of them are written, so a
+// failed write leaves the old certificate and key together.
+func replaceFiles(paths []string, contents [][]byte) error {
+	var written []string
+	for i, path := range paths {
+		if err := writeFile(path+".new", contents[i]); err != nil {
+			for _, tmp := range written {
+				os.Remove(tmp)
+			}
+			return err
+		}
+		written = append(written, path+".new")
+	}
+	for _, path := range paths {
+		if err := os.Rename(path+".new", path); err != nil {
+			return fmt.Errorf("replace %q failed, error %v", path, err)
+		}
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// runExpiry reports certificates expiring within -within and, with -renew,
+// re-issues the leaves among them.
+func runExpiry(args []string) {
+	flags := flag.NewFlagSet("expiry", flag.ExitOnError)
+	dirs := flags.String("dir", "conf/cert*", "comma separated directory globs to scan, the default covers conf/certs and the conf/certN directories of bootstrap https")
+	within := flags.Duration("within", 30*24*time.Hour, "report certificates expiring within this duration")
+	format := flags.String("format", "table", "output format: table or json")
+	renew := flags.Bool("renew", false, "re-issue the expiring leaves from their original CA")
+	rotateKey := flags.Bool("rotate-key", false, "generate new keys when renewing instead of keeping the existing ones")
+	validityDays := flags.Int("validity-days", 0, "days renewed certificates are valid, defaults to the period of the certificate they replace")
+	keyPasswordValue := flags.String("key-password", "", "password of encrypted CA and leaf keys, also used for rotated keys")
+	keyPasswordFile := flags.String("key-password-file", "", "file holding the password of encrypted keys")
+	pkcs12PasswordValue := flags.String("pkcs12-password", "", "password of the PKCS#12 bundles rewritten on renewal")
+	pkcs12PasswordFile := flags.String("pkcs12-password-file", "", "file holding the password of the PKCS#12 bundles")
+	flags.Parse(args)
+
+	expiring, err := scanExpiring(strings.Split(*dirs, ","), *within, time.Now())
+	if err != nil {
+		log.Fatalf("scan certificates failed, error %v", err)
+	}
+	if err := writeExpiring(os.Stdout, expiring, *format); err != nil {
+		log.Fatalf("write report failed, error %v", err)
+	}
+	if !*renew {
+		return
+	}
+	keyPassword, err := utils.ResolvePassword(*keyPasswordValue, *keyPasswordFile, "CERT_KEY_PASSWORD")
+	if err != nil {
+		log.Fatalf("resolve key password failed, error %v", err)
+	}
+	pkcs12Password, err := utils.ResolvePassword(*pkcs12PasswordValue, *pkcs12PasswordFile, "CERT_PKCS12_PASSWORD")
+	if err != nil {
+		log.Fatalf("resolve pkcs12 password failed, error %v", err)
+	}
+	opts := RenewOptions{RotateKey: *rotateKey, ValidityDays: *validityDays, KeyPassword: keyPassword, PKCS12Password: pkcs12Password}
+	failed := 0
+	for _, e := range expiring {
+		if e.IsCA {
+			log.Printf("skip ca certificate %s, expires %s", e.File, e.NotAfter.Format(time.RFC3339))
+			continue
+		}
+		renewed, err := renewCert(e.File, e.Cert, opts)
+		if err != nil {
+			log.Printf("renew %s failed, error %v", e.File, err)
+			failed++
+			continue
+		}
+		log.Printf("renewed %s, serial %s valid until %s", e.File, utils.FormatSerial(renewed.SerialNumber), renewed.NotAfter.Format(time.RFC3339))
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d certificates could not be renewed", failed, len(expiring))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+func TestExpiryRenewal(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p256",
+		CAs:          []CASpec{{Name: "ca0"}, {Name: "ca1"}},
+		CrossSigns:   []CrossSignSpec{{Name: "ca01", Issuer: "ca0", Subject: "ca1"}},
+		Leaves: []LeafSpec{
+			{Name: "server1", Issuer: "ca1"},
+			{Name: "client1", Issuer: "ca01", Profile: "tls-client", DNSNames: []string{"client.dev.local"}, IPAddresses: []string{"127.0.0.1"}, ValidityDays: 10},
+		},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.crt"), []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expiring, err := scanExpiring([]string{dir}, 30*24*time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPath := filepath.Join(dir, "client1.crt")
+	if len(expiring) != 1 || expiring[0].File != clientPath || expiring[0].DaysLeft != 9 {
+		t.Fatalf("expiring certificates are %+v", expiring)
+	}
+
+	old := expiring[0].Cert
+	renewed, err := renewCert(clientPath, old, RenewOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.SerialNumber.Cmp(old.SerialNumber) == 0 || renewed.NotAfter.Before(old.NotAfter) {
+		t.Errorf("renewed serial %x until %v, old serial %x until %v", renewed.SerialNumber, renewed.NotAfter, old.SerialNumber, old.NotAfter)
+	}
+	if renewed.Subject.String() != old.Subject.String() || !reflect.DeepEqual(renewed.DNSNames, old.DNSNames) || len(renewed.IPAddresses) != 1 || !renewed.IPAddresses[0].Equal(old.IPAddresses[0]) {
+		t.Errorf("renewed %v %v %v, want %v %v %v", renewed.Subject, renewed.DNSNames, renewed.IPAddresses, old.Subject, old.DNSNames, old.IPAddresses)
+	}
+	if !reflect.DeepEqual(renewed.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) || !reflect.DeepEqual(renewed.OCSPServer, old.OCSPServer) {
+		t.Errorf("renewed ext key usage %v, ocsp %v", renewed.ExtKeyUsage, renewed.OCSPServer)
+	}
+	if !renewed.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(old.PublicKey) {
+		t.Error("renewal without rotation changed the key")
+	}
+
+	rotated, err := renewCert(clientPath, renewed, RenewOptions{RotateKey: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(old.PublicKey) {
+		t.Error("renewal with rotation kept the key")
+	}
+	if _, err := utils.LoadX509KeyPair(clientPath, filepath.Join(dir, "client1.key"), ""); err != nil {
+		t.Errorf("rotated key does not match the certificate, error %v", err)
+	}
+	// a certificate that cannot be written leaves the rotated key in place
+	if err := os.Mkdir(clientPath+".new", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renewCert(clientPath, rotated, RenewOptions{RotateKey: true}); err == nil {
+		t.Error("expected the renewal to fail writing the certificate")
+	}
+	if _, err := utils.LoadX509KeyPair(clientPath, filepath.Join(dir, "client1.key"), ""); err != nil {
+		t.Errorf("failed renewal separated the key from the certificate, error %v", err)
+	}
+	entries, err := utils.ReadIndex(filepath.Join(dir, "ca1.index.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utils.FindIndexEntry(entries, rotated.SerialNumber) == nil || len(entries) != 5 {
+		t.Errorf("ca1 database has %d entries, want the ca, 2 leaves and 2 renewals", len(entries))
+	}
+
+	ca := readCert(t, filepath.Join(dir, "ca1.crt"))
+	if _, err := renewCert(filepath.Join(dir, "ca1.crt"), ca, RenewOptions{}); err == nil {
+		t.Error("expected ca renewal to be refused")
+	}
+}
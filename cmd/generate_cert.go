@@ -1,354 +1,375 @@
 package main
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
+	"flag"
 	"fmt"
 	"log"
-	"math/big"
 	"net"
 	"os"
-	"time"
+	"strings"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
 )
 
-func generatePrivateKey() (*rsa.PrivateKey, []byte, error) {
-	priKey4096 := 4096
-	rsaPrivateKey, err := rsa.GenerateKey(rand.Reader, priKey4096)
-	if err != nil {
-		log.Fatalf("generate key failed, %v", err)
-	}
-	pkcsPrivateKey, err := x509.MarshalPKCS8PrivateKey(rsaPrivateKey)
+func GenerateCert(ipString, serviceName, keyAlgorithm string) {
+	privateKey, privateKeyBlockByte, err := pki.GeneratePrivateKey(rand.Reader, keyAlgorithm, "")
 	if err != nil {
-		log.Fatalf("create private key failed, error %v", err)
+		log.Fatalf("get privateKey Block failed, error %v", err)
 	}
-	privateBlock := pem.Block{Type: "RSA PRIVATE KEY", Bytes: pkcsPrivateKey}
-	return rsaPrivateKey, pem.EncodeToMemory(&privateBlock), nil
-}
-
-func SignCACert(privateKey *rsa.PrivateKey, serviceName string) []byte {
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		log.Fatalf("Failed to generate serial number: %v", err)
-	}
-	before := time.Now()
-	after := before.AddDate(1, 0, 0)
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Country:            []string{"CN"},
-			Province:           []string{"BeiJing"},
-			Organization:       []string{"devCompany"},
-			OrganizationalUnit: []string{"devTeam"},
-			CommonName:         serviceName,
-		},
-		NotBefore:             before,
-		NotAfter:              after,
-		BasicConstraintsValid: true,
-		IsCA:                  true,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageEmailProtection, x509.ExtKeyUsageIPSECTunnel, x509.ExtKeyUsageIPSECUser},
-		SignatureAlgorithm:    x509.SHA256WithRSA,
-		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
-		DNSNames:              []string{serviceName},
-	}
-	caCertByte, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	log.Println(string(privateKeyBlockByte))
+	csrCertificate, err := generateCsr(privateKey, NameSpec{Subject: "/CN=" + serviceName, SANs: []string{"DNS:" + serviceName, "IP:" + ipString}})
 	if err != nil {
-		log.Fatalf("create ca certificate failed, error %v", err)
+		log.Fatalf("generate csr failed, error %v", err)
 	}
-	caCertBlock := pem.Block{Type: "CERTIFICATE", Bytes: caCertByte}
-	caCertBlockBytes := pem.EncodeToMemory(&caCertBlock)
-	return caCertBlockBytes
+	log.Println(string(csrCertificate))
 }
 
-func SignCrossCert(privateKey *rsa.PrivateKey, caCertBlockBytes, certBlockBytes []byte) []byte {
-	certBlock, _ := pem.Decode(certBlockBytes)
-	cert, err := x509.ParseCertificate(certBlock.Bytes)
-	if err != nil {
-		log.Fatalf("parse certificate failed, error %v", err)
-	}
-	caCertBlock, _ := pem.Decode(caCertBlockBytes)
-	caCert, err := x509.ParseCertificate(caCertBlock.Bytes)
-	if err != nil {
-		log.Fatalf("parse ca certificate failed, error %v", err)
-	}
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+// generateCsr requests the names of spec, DevelopService at 127.0.0.1 for
+// what it leaves out.
+func generateCsr(privateKey crypto.Signer, spec NameSpec) ([]byte, error) {
+	csrOpts, err := spec.csrOptions("DevelopService", nil, nil)
 	if err != nil {
-		log.Fatalf("Failed to generate serial number: %v", err)
+		return nil, err
 	}
-	before := time.Now()
-	after := before.AddDate(1, 0, 0)
-
-	template := &x509.Certificate{
-		SerialNumber:          serialNumber,
-		Subject:               cert.Subject,
-		NotBefore:             before,
-		NotAfter:              after,
-		BasicConstraintsValid: true,
-		IsCA:                  false,
-		KeyUsage:              cert.KeyUsage,
-		ExtKeyUsage:           cert.ExtKeyUsage,
-		SignatureAlgorithm:    cert.SignatureAlgorithm,
-		IPAddresses:           cert.IPAddresses,
-		DNSNames:              cert.DNSNames,
-	}
-	crossCertByte, err := x509.CreateCertificate(rand.Reader, template, caCert, cert.PublicKey, privateKey)
-	if err != nil {
-		log.Fatalf("create ca certificate failed, error %v", err)
+	if csrOpts.SANs.Empty() {
+		csrOpts.DNSNames = []string{csrOpts.Subject.CommonName}
+		csrOpts.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
 	}
-	crossCertBlock := pem.Block{Type: "CERTIFICATE", Bytes: crossCertByte}
-	crossCertBlockBytes := pem.EncodeToMemory(&crossCertBlock)
-	return crossCertBlockBytes
+	return pki.CreateCSR(rand.Reader, privateKey, csrOpts)
 }
 
-func SignServerCert(csrBlockBytes, caCertBlockBytes []byte, caPrivateKey *rsa.PrivateKey) []byte {
-	csrBlock, _ := pem.Decode(csrBlockBytes)
-	csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
-	if err != nil {
-		log.Fatalf("parser csr failed, error %v", err)
-	}
-	caCertBlock, _ := pem.Decode(caCertBlockBytes)
-	caCert, err := x509.ParseCertificate(caCertBlock.Bytes)
-	if err != nil {
-		log.Fatalf("parser caCert failed, error %v", err)
-	}
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		log.Fatalf("Failed to generate serial number: %v", err)
-	}
-	before := time.Now()
-	after := before.AddDate(1, 0, 0)
-	serverCert := x509.Certificate{
-		SerialNumber:          serialNumber,
-		Subject:               csr.Subject,
-		NotBefore:             before,
-		NotAfter:              after,
-		BasicConstraintsValid: true,
-		IsCA:                  false,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageContentCommitment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		SignatureAlgorithm:    csr.SignatureAlgorithm,
-		IPAddresses:           csr.IPAddresses,
-		DNSNames:              csr.DNSNames,
-	}
-	serverCertByte, err := x509.CreateCertificate(rand.Reader, &serverCert, caCert, csr.PublicKey, caPrivateKey)
-	if err != nil {
-		log.Fatalf("create server certificate failed, error %v", err)
-	}
-	serverCertBlock := pem.Block{Type: "CERTIFICATE", Bytes: serverCertByte}
-	serverCertBlockBytes := pem.EncodeToMemory(&serverCertBlock)
-	return serverCertBlockBytes
+// GenerateNames holds the names, validity and key algorithms the generate
+// flags set for the CAs and the leaves of the built-in layouts.
+type GenerateNames struct {
+	CA             NameSpec
+	Leaf           NameSpec
+	CAKeyAlgorithm string
+	KeyAlgorithm   string
 }
 
-func GenerateCert(ipString, serviceName string) {
-	privateKey, privateKeyBlockByte, err := generatePrivateKey()
-	if err != nil {
-		log.Fatalf("get privateKey Block failed, error %v", err)
+// options returns the certificate options of the CAs and of the leaves.
+func (n GenerateNames) options() (pki.CertOptions, pki.CertOptions, error) {
+	var caOpts, leafOpts pki.CertOptions
+	if err := n.CA.apply(&caOpts, true); err != nil {
+		return caOpts, leafOpts, fmt.Errorf("ca names: %v", err)
 	}
-	log.Println(string(privateKeyBlockByte))
-	csrCertificate, err := generateCsr(privateKey, ipString, serviceName)
-	if err != nil {
-		log.Fatalf("generate csr failed, error %v", err)
+	if err := n.Leaf.apply(&leafOpts, false); err != nil {
+		return caOpts, leafOpts, fmt.Errorf("leaf names: %v", err)
 	}
-	log.Println(string(csrCertificate))
+	return caOpts, leafOpts, nil
 }
 
-func generateCsr(rsaPriKey *rsa.PrivateKey, ipv4string, commonName string) ([]byte, error) {
-	certRequest := &x509.CertificateRequest{
-		Subject: pkix.Name{
-			CommonName:         commonName,
-			Country:            nil,
-			Province:           nil,
-			Locality:           nil,
-			Organization:       nil,
-			OrganizationalUnit: nil,
-		},
-		IPAddresses:        []net.IP{net.ParseIP(ipv4string)},
-		DNSNames:           []string{commonName},
-		SignatureAlgorithm: x509.SHA256WithRSA,
-	}
-	csrByte, err := x509.CreateCertificateRequest(rand.Reader, certRequest, rsaPriKey)
-	if err != nil {
-		log.Printf("create certificate request failed, error %v", err)
-		return nil, err
-	}
-	csrBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrByte})
-	return csrBytes, nil
+// numbered gives each CA of a layout its own common name, the one of the
+// subject or DevCAService followed by i.
+func numbered(opts pki.CertOptions, i int) (pki.CertOptions, string) {
+	commonName := fmt.Sprintf("DevCAService%d", i)
+	if opts.Subject != nil && opts.Subject.CommonName != "" {
+		subject := *opts.Subject
+		subject.CommonName = fmt.Sprintf("%s%d", subject.CommonName, i)
+		opts.Subject, commonName = &subject, subject.CommonName
+	}
+	return opts, commonName
 }
 
 func writeFile(path string, content []byte) error {
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Printf("create or open file %q failed, error %v", path, err)
-		return err
-	}
-	defer f.Close()
-	if _, err := f.Write(content); err != nil {
-		log.Printf("write file %q failed, error %v", path, err)
+	if err := pki.WriteFile(path, content); err != nil {
+		log.Printf("%v", err)
 		return err
 	}
 	return nil
 }
 
-func generateCrossCert() {
+// writeIssuedCert writes certBlock to file and records it in the database of
+// the CA at caCertPath, failures are logged like the other writes here.
+func writeIssuedCert(caCertPath, file string, certBlock []byte) {
+	if err := writeFile(file, certBlock); err != nil {
+		return
+	}
+	if err := pki.RecordIssuance(caCertPath, certBlock, file); err != nil {
+		log.Printf("record %q in the database of %q failed, error %v", file, caCertPath, err)
+	}
+}
+
+func generateCrossCert(output OutputOptions, names GenerateNames) {
+	caOpts, leafOpts, err := names.options()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	clientOpts := leafOpts
+	clientOpts.Profile = "tls-client"
 	counts := 3
 
-	caPrivateKeys := make([]*rsa.PrivateKey, counts, counts)
+	caPrivateKeys := make([]crypto.Signer, counts, counts)
 	caPrivateKeyBlockBytes := make([][]byte, counts, counts)
 	caCertBlockBytes := make([][]byte, counts, counts)
-	var err error
 
 	for i := 0; i < counts; i++ {
 		log.Printf("generate caPrivateKey %d", i)
-		caPrivateKeys[i], caPrivateKeyBlockBytes[i], err = generatePrivateKey()
+		caPrivateKeys[i], caPrivateKeyBlockBytes[i], err = pki.GeneratePrivateKey(rand.Reader, names.CAKeyAlgorithm, output.KeyPassword)
 		if err != nil {
 			log.Fatalf("generate privatekey %d failed, err %v", i, err)
 		}
 		writeFile(fmt.Sprintf("conf/certs/ca%d.key", i), caPrivateKeyBlockBytes[i])
 		log.Printf("generate caCertBlockBytes %d", i)
-		caCertBlockBytes[i] = SignCACert(caPrivateKeys[i], fmt.Sprintf("DevCAService%d", i))
-		writeFile(fmt.Sprintf("conf/certs/ca%d.crt", i), caCertBlockBytes[i])
-	}
-	cert01 := SignCrossCert(caPrivateKeys[0], caCertBlockBytes[0], caCertBlockBytes[1])
-	writeFile(fmt.Sprintf("conf/certs/ca%s.crt", "01"), cert01)
-	cert10 := SignCrossCert(caPrivateKeys[1], caCertBlockBytes[1], caCertBlockBytes[0])
-	writeFile(fmt.Sprintf("conf/certs/ca%s.crt", "10"), cert10)
-	cert12 := SignCrossCert(caPrivateKeys[1], caCertBlockBytes[1], caCertBlockBytes[2])
-	writeFile(fmt.Sprintf("conf/certs/ca%s.crt", "12"), cert12)
-	cert21 := SignCrossCert(caPrivateKeys[2], caCertBlockBytes[2], caCertBlockBytes[1])
-	writeFile(fmt.Sprintf("conf/certs/ca%s.crt", "21"), cert21)
+		opts, commonName := numbered(caOpts, i)
+		caCertBlockBytes[i], err = pki.SignCACert(rand.Reader, caPrivateKeys[i], commonName, opts)
+		if err != nil {
+			log.Fatalf("sign caCertBlockBytes failed, error %v", err)
+		}
+		if err := pki.StartIndex(fmt.Sprintf("conf/certs/ca%d.crt", i)); err != nil {
+			log.Fatalf("start database of ca%d failed, error %v", i, err)
+		}
+		writeIssuedCert(fmt.Sprintf("conf/certs/ca%d.crt", i), fmt.Sprintf("conf/certs/ca%d.crt", i), caCertBlockBytes[i])
+	}
+	cert01, err := pki.SignCrossCert(rand.Reader, caPrivateKeys[0], caCertBlockBytes[0], caCertBlockBytes[1], caOpts)
+	if err != nil {
+		log.Fatalf("sign cert01 failed, error %v", err)
+	}
+	writeIssuedCert("conf/certs/ca0.crt", fmt.Sprintf("conf/certs/ca%s.crt", "01"), cert01)
+	cert10, err := pki.SignCrossCert(rand.Reader, caPrivateKeys[1], caCertBlockBytes[1], caCertBlockBytes[0], caOpts)
+	if err != nil {
+		log.Fatalf("sign cert10 failed, error %v", err)
+	}
+	writeIssuedCert("conf/certs/ca1.crt", fmt.Sprintf("conf/certs/ca%s.crt", "10"), cert10)
+	cert12, err := pki.SignCrossCert(rand.Reader, caPrivateKeys[1], caCertBlockBytes[1], caCertBlockBytes[2], caOpts)
+	if err != nil {
+		log.Fatalf("sign cert12 failed, error %v", err)
+	}
+	writeIssuedCert("conf/certs/ca1.crt", fmt.Sprintf("conf/certs/ca%s.crt", "12"), cert12)
+	cert21, err := pki.SignCrossCert(rand.Reader, caPrivateKeys[2], caCertBlockBytes[2], caCertBlockBytes[1], caOpts)
+	if err != nil {
+		log.Fatalf("sign cert21 failed, error %v", err)
+	}
+	writeIssuedCert("conf/certs/ca2.crt", fmt.Sprintf("conf/certs/ca%s.crt", "21"), cert21)
 
 	n := "1"
-	serverPrivateKey1, serverPrivateKeyBlockByte1, err := generatePrivateKey()
+	serverPrivateKey1, serverPrivateKeyBlockByte1, err := pki.GeneratePrivateKey(rand.Reader, names.KeyAlgorithm, output.KeyPassword)
 	if err != nil {
 		log.Fatalf("get serverPrivateKey%s Block failed, error %v", n, err)
 	}
 	writeFile(fmt.Sprintf("conf/certs/server%s.key", n), serverPrivateKeyBlockByte1)
 
 	log.Printf("generate serverCsr%s ", n)
-	serverCsrBlockBytes1, err := generateCsr(serverPrivateKey1, "127.0.0.1", "DevelopService")
+	serverCsrBlockBytes1, err := generateCsr(serverPrivateKey1, names.Leaf)
 	if err != nil {
 		log.Fatalf("get serverCsr%s failed, error %v", n, err)
 	}
 	log.Printf("generate serverCert%s ", n)
-	//    serverCertBytes1 := SignServerCert(serverCsrBlockBytes1, cert01, caPrivateKeys[1])
-	serverCertBytes1 := SignServerCert(serverCsrBlockBytes1, caCertBlockBytes[1], caPrivateKeys[1])
-	writeFile(fmt.Sprintf("conf/certs/server%s.crt", n), serverCertBytes1)
+	//    serverCertBytes1 := SignServerCert(serverCsrBlockBytes1, cert01, caPrivateKeys[1], pki.CertOptions{})
+	serverCertBytes1, err := pki.SignServerCert(rand.Reader, serverCsrBlockBytes1, caCertBlockBytes[1], caPrivateKeys[1], leafOpts)
+	if err != nil {
+		log.Fatalf("sign serverCertBytes1 failed, error %v", err)
+	}
+	writeIssuedCert("conf/certs/ca1.crt", fmt.Sprintf("conf/certs/server%s.crt", n), serverCertBytes1)
+	if output.PKCS12 {
+		if err := writePKCS12(fmt.Sprintf("conf/certs/server%s.p12", n), serverPrivateKey1, serverCertBytes1, [][]byte{caCertBlockBytes[1]}, output.PKCS12Password); err != nil {
+			log.Fatalf("write server%s pkcs12 failed, error %v", n, err)
+		}
+	}
 
 	log.Printf("generate clientPrivateKey%s ", n)
-	clientPrivateKey1, clientPrivateKeyBlockByte1, err := generatePrivateKey()
+	clientPrivateKey1, clientPrivateKeyBlockByte1, err := pki.GeneratePrivateKey(rand.Reader, names.KeyAlgorithm, output.KeyPassword)
 	if err != nil {
 		log.Fatalf("get clientPrivateKey%s Block failed, error %v", n, err)
 	}
 	writeFile(fmt.Sprintf("conf/certs/client%s.key", n), clientPrivateKeyBlockByte1)
 
 	log.Printf("generate clientCsr%s ", n)
-	clientCsrBlockBytes1, err := generateCsr(clientPrivateKey1, "127.0.0.1", "DevelopService")
+	clientCsrBlockBytes1, err := generateCsr(clientPrivateKey1, names.Leaf)
 	if err != nil {
 		log.Fatalf("get serverCsr%s failed, error %v", n, err)
 	}
 	log.Printf("generate clientCert%s ", n)
-	//    clientCertBytes1 := SignServerCert(clientCsrBlockBytes1, cert01, caPrivateKeys[1])
-	clientCertBytes1 := SignServerCert(clientCsrBlockBytes1, caCertBlockBytes[1], caPrivateKeys[1])
-	writeFile(fmt.Sprintf("conf/certs/client%s.crt", n), clientCertBytes1)
+	//    clientCertBytes1 := SignServerCert(clientCsrBlockBytes1, cert01, caPrivateKeys[1], pki.CertOptions{})
+	clientCertBytes1, err := pki.SignServerCert(rand.Reader, clientCsrBlockBytes1, caCertBlockBytes[1], caPrivateKeys[1], clientOpts)
+	if err != nil {
+		log.Fatalf("sign clientCertBytes1 failed, error %v", err)
+	}
+	writeIssuedCert("conf/certs/ca1.crt", fmt.Sprintf("conf/certs/client%s.crt", n), clientCertBytes1)
+	if output.PKCS12 {
+		if err := writePKCS12(fmt.Sprintf("conf/certs/client%s.p12", n), clientPrivateKey1, clientCertBytes1, [][]byte{caCertBlockBytes[1]}, output.PKCS12Password); err != nil {
+			log.Fatalf("write client%s pkcs12 failed, error %v", n, err)
+		}
+	}
 	n = "2"
-	serverPrivateKey2, serverPrivateKeyBlockByte2, err := generatePrivateKey()
+	serverPrivateKey2, serverPrivateKeyBlockByte2, err := pki.GeneratePrivateKey(rand.Reader, names.KeyAlgorithm, output.KeyPassword)
 	if err != nil {
 		log.Fatalf("get serverPrivateKey%s Block failed, error %v", n, err)
 	}
 	writeFile(fmt.Sprintf("conf/certs/server%s.key", n), serverPrivateKeyBlockByte2)
 
 	log.Printf("generate serverCsr%s ", n)
-	serverCsrBlockBytes2, err := generateCsr(serverPrivateKey2, "127.0.0.1", "DevelopService")
+	serverCsrBlockBytes2, err := generateCsr(serverPrivateKey2, names.Leaf)
 	if err != nil {
 		log.Fatalf("get serverCsr%s failed, error %v", n, err)
 	}
 	log.Printf("generate serverCert%s ", n)
-	//    serverCertBytes2 := SignServerCert(serverCsrBlockBytes2, cert12, caPrivateKeys[2])
-	serverCertBytes2 := SignServerCert(serverCsrBlockBytes2, caCertBlockBytes[2], caPrivateKeys[2])
-	writeFile(fmt.Sprintf("conf/certs/server%s.crt", n), serverCertBytes2)
+	//    serverCertBytes2 := SignServerCert(serverCsrBlockBytes2, cert12, caPrivateKeys[2], pki.CertOptions{})
+	serverCertBytes2, err := pki.SignServerCert(rand.Reader, serverCsrBlockBytes2, caCertBlockBytes[2], caPrivateKeys[2], leafOpts)
+	if err != nil {
+		log.Fatalf("sign serverCertBytes2 failed, error %v", err)
+	}
+	writeIssuedCert("conf/certs/ca2.crt", fmt.Sprintf("conf/certs/server%s.crt", n), serverCertBytes2)
+	if output.PKCS12 {
+		if err := writePKCS12(fmt.Sprintf("conf/certs/server%s.p12", n), serverPrivateKey2, serverCertBytes2, [][]byte{caCertBlockBytes[2]}, output.PKCS12Password); err != nil {
+			log.Fatalf("write server%s pkcs12 failed, error %v", n, err)
+		}
+	}
 
 	log.Printf("generate clientPrivateKey%s ", n)
-	clientPrivateKey2, clientPrivateKeyBlockByte2, err := generatePrivateKey()
+	clientPrivateKey2, clientPrivateKeyBlockByte2, err := pki.GeneratePrivateKey(rand.Reader, names.KeyAlgorithm, output.KeyPassword)
 	if err != nil {
 		log.Fatalf("get clientPrivateKey%s Block failed, error %v", n, err)
 	}
 	writeFile(fmt.Sprintf("conf/certs/client%s.key", n), clientPrivateKeyBlockByte2)
 
 	log.Printf("generate clientCsr%s ", n)
-	clientCsrBlockBytes2, err := generateCsr(clientPrivateKey2, "127.0.0.1", "DevelopService")
+	clientCsrBlockBytes2, err := generateCsr(clientPrivateKey2, names.Leaf)
 	if err != nil {
 		log.Fatalf("get serverCsr%s failed, error %v", n, err)
 	}
 	log.Printf("generate clientCert%s ", n)
-	//    clientCertBytes2 := SignServerCert(clientCsrBlockBytes2, cert12, caPrivateKeys[2])
-	clientCertBytes2 := SignServerCert(clientCsrBlockBytes2, caCertBlockBytes[2], caPrivateKeys[2])
-	writeFile(fmt.Sprintf("conf/certs/client%s.crt", n), clientCertBytes2)
+	//    clientCertBytes2 := SignServerCert(clientCsrBlockBytes2, cert12, caPrivateKeys[2], pki.CertOptions{})
+	clientCertBytes2, err := pki.SignServerCert(rand.Reader, clientCsrBlockBytes2, caCertBlockBytes[2], caPrivateKeys[2], clientOpts)
+	if err != nil {
+		log.Fatalf("sign clientCertBytes2 failed, error %v", err)
+	}
+	writeIssuedCert("conf/certs/ca2.crt", fmt.Sprintf("conf/certs/client%s.crt", n), clientCertBytes2)
+	if output.PKCS12 {
+		if err := writePKCS12(fmt.Sprintf("conf/certs/client%s.p12", n), clientPrivateKey2, clientCertBytes2, [][]byte{caCertBlockBytes[2]}, output.PKCS12Password); err != nil {
+			log.Fatalf("write client%s pkcs12 failed, error %v", n, err)
+		}
+	}
 }
 
-func generateCerts(n string) {
+func generateCerts(n string, output OutputOptions, names GenerateNames) {
+	caOpts, leafOpts, err := names.options()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	clientOpts := leafOpts
+	clientOpts.Profile = "tls-client"
 	log.Printf("generate caPrivateKey%s ", n)
-	caPrivateKey1, caPrivateKeyBlockByte1, err := generatePrivateKey()
+	caPrivateKey1, caPrivateKeyBlockByte1, err := pki.GeneratePrivateKey(rand.Reader, names.CAKeyAlgorithm, output.KeyPassword)
 	if err != nil {
 		log.Fatalf("get caPrivateKey%s Block failed, error %v", n, err)
 	}
 	writeFile(fmt.Sprintf("conf/certs/ca%s.key", n), caPrivateKeyBlockByte1)
 	log.Printf("generate caCert%s ", n)
-	caCertBytes1 := SignCACert(caPrivateKey1, "DevService")
-	writeFile(fmt.Sprintf("conf/certs/ca%s.crt", n), caCertBytes1)
+	caCertBytes1, err := pki.SignCACert(rand.Reader, caPrivateKey1, "DevService", caOpts)
+	if err != nil {
+		log.Fatalf("sign caCertBytes1 failed, error %v", err)
+	}
+	if err := pki.StartIndex(fmt.Sprintf("conf/certs/ca%s.crt", n)); err != nil {
+		log.Fatalf("start database of ca%s failed, error %v", n, err)
+	}
+	writeIssuedCert(fmt.Sprintf("conf/certs/ca%s.crt", n), fmt.Sprintf("conf/certs/ca%s.crt", n), caCertBytes1)
 
 	log.Printf("generate serverPrivateKey%s ", n)
-	serverPrivateKey1, serverPrivateKeyBlockByte1, err := generatePrivateKey()
+	serverPrivateKey1, serverPrivateKeyBlockByte1, err := pki.GeneratePrivateKey(rand.Reader, names.KeyAlgorithm, output.KeyPassword)
 	if err != nil {
 		log.Fatalf("get serverPrivateKey%s Block failed, error %v", n, err)
 	}
 	writeFile(fmt.Sprintf("conf/certs/server%s.key", n), serverPrivateKeyBlockByte1)
 
 	log.Printf("generate serverCsr%s ", n)
-	serverCsrBlockBytes1, err := generateCsr(serverPrivateKey1, "127.0.0.1", "DevelopService")
+	serverCsrBlockBytes1, err := generateCsr(serverPrivateKey1, names.Leaf)
 	if err != nil {
 		log.Fatalf("get serverCsr%s failed, error %v", n, err)
 	}
 	log.Printf("generate serverCert%s ", n)
-	serverCertBytes1 := SignServerCert(serverCsrBlockBytes1, caCertBytes1, caPrivateKey1)
-	writeFile(fmt.Sprintf("conf/certs/server%s.crt", n), serverCertBytes1)
+	serverCertBytes1, err := pki.SignServerCert(rand.Reader, serverCsrBlockBytes1, caCertBytes1, caPrivateKey1, leafOpts)
+	if err != nil {
+		log.Fatalf("sign serverCertBytes1 failed, error %v", err)
+	}
+	writeIssuedCert(fmt.Sprintf("conf/certs/ca%s.crt", n), fmt.Sprintf("conf/certs/server%s.crt", n), serverCertBytes1)
+	if output.PKCS12 {
+		if err := writePKCS12(fmt.Sprintf("conf/certs/server%s.p12", n), serverPrivateKey1, serverCertBytes1, [][]byte{caCertBytes1}, output.PKCS12Password); err != nil {
+			log.Fatalf("write server%s pkcs12 failed, error %v", n, err)
+		}
+	}
 
 	log.Printf("generate clientPrivateKey%s ", n)
-	clientPrivateKey1, clientPrivateKeyBlockByte1, err := generatePrivateKey()
+	clientPrivateKey1, clientPrivateKeyBlockByte1, err := pki.GeneratePrivateKey(rand.Reader, names.KeyAlgorithm, output.KeyPassword)
 	if err != nil {
 		log.Fatalf("get clientPrivateKey%s Block failed, error %v", n, err)
 	}
 	writeFile(fmt.Sprintf("conf/certs/client%s.key", n), clientPrivateKeyBlockByte1)
 
 	log.Printf("generate clientCsr%s ", n)
-	clientCsrBlockBytes1, err := generateCsr(clientPrivateKey1, "127.0.0.1", "DevelopService")
+	clientCsrBlockBytes1, err := generateCsr(clientPrivateKey1, names.Leaf)
 	if err != nil {
 		log.Fatalf("get serverCsr%s failed, error %v", n, err)
 	}
 	log.Printf("generate clientCert%s ", n)
-	clientCertBytes1 := SignServerCert(clientCsrBlockBytes1, caCertBytes1, caPrivateKey1)
-	writeFile(fmt.Sprintf("conf/certs/client%s.crt", n), clientCertBytes1)
+	clientCertBytes1, err := pki.SignServerCert(rand.Reader, clientCsrBlockBytes1, caCertBytes1, caPrivateKey1, clientOpts)
+	if err != nil {
+		log.Fatalf("sign clientCertBytes1 failed, error %v", err)
+	}
+	writeIssuedCert(fmt.Sprintf("conf/certs/ca%s.crt", n), fmt.Sprintf("conf/certs/client%s.crt", n), clientCertBytes1)
+	if output.PKCS12 {
+		if err := writePKCS12(fmt.Sprintf("conf/certs/client%s.p12", n), clientPrivateKey1, clientCertBytes1, [][]byte{caCertBytes1}, output.PKCS12Password); err != nil {
+			log.Fatalf("write client%s pkcs12 failed, error %v", n, err)
+		}
+	}
 }
 
-func main() {
+func runGenerate(args []string) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	topologyFile := flags.String("topology", "", "YAML or JSON file describing the CAs, cross certs and leaves to generate")
+	keyPasswordValue := flags.String("key-password", "", "encrypt generated private keys as PKCS#8 with this password")
+	keyPasswordFile := flags.String("key-password-file", "", "file holding the password of generated private keys")
+	pkcs12Export := flags.Bool("pkcs12", false, "also write a PKCS#12 bundle with key, cert and chain next to each leaf")
+	pkcs12PasswordValue := flags.String("pkcs12-password", "", "password of the PKCS#12 bundles")
+	pkcs12PasswordFile := flags.String("pkcs12-password-file", "", "file holding the password of the PKCS#12 bundles")
+	var names GenerateNames
+	names.CA.register(flags, "ca-", "CAs, the CA number is appended to its CN")
+	names.Leaf.register(flags, "", "servers and clients")
+	caKeyAlgorithm := flags.String("ca-key-algorithm", pki.DefaultKeyAlgorithm, "key algorithm of the CAs, one of "+strings.Join(pki.KeyAlgorithms, ", "))
+	keyAlgorithm := flags.String("key-algorithm", pki.DefaultKeyAlgorithm, "key algorithm of the servers and clients, one of "+strings.Join(pki.KeyAlgorithms, ", "))
+	flags.Parse(args)
+	keyPassword, err := utils.ResolvePassword(*keyPasswordValue, *keyPasswordFile, "CERT_KEY_PASSWORD")
+	if err != nil {
+		log.Fatalf("resolve key password failed, error %v", err)
+	}
+	pkcs12Password, err := utils.ResolvePassword(*pkcs12PasswordValue, *pkcs12PasswordFile, "CERT_PKCS12_PASSWORD")
+	if err != nil {
+		log.Fatalf("resolve pkcs12 password failed, error %v", err)
+	}
+	if names.CAKeyAlgorithm, err = pki.NormalizeKeyAlgorithm(*caKeyAlgorithm); err != nil {
+		log.Fatalf("-ca-key-algorithm: %v", err)
+	}
+	if names.KeyAlgorithm, err = pki.NormalizeKeyAlgorithm(*keyAlgorithm); err != nil {
+		log.Fatalf("-key-algorithm: %v", err)
+	}
+	output := OutputOptions{KeyPassword: keyPassword, PKCS12: *pkcs12Export, PKCS12Password: pkcs12Password}
+	if *topologyFile != "" {
+		topology, err := loadTopology(*topologyFile)
+		if err != nil {
+			log.Fatalf("load topology failed, error %v", err)
+		}
+		topology.Output = output
+		if err := topology.Generate(); err != nil {
+			log.Fatalf("generate topology %q failed, error %v", *topologyFile, err)
+		}
+		return
+	}
 	//    number := os.Args[1]
-	//    generateCerts(number)
+	//    generateCerts(number, output, names)
 	/**
 	  n := "3"
 	  log.Printf("generate caPrivateKey%s ", n)
-	  caPrivateKey1, caPrivateKeyBlockByte1, err := generatePrivateKey()
+	  caPrivateKey1, caPrivateKeyBlockByte1, err := pki.GeneratePrivateKey(rand.Reader, pki.DefaultKeyAlgorithm, output.KeyPassword)
 	  if err != nil {
 	      log.Fatalf("get caPrivateKey%s Block failed, error %v", n, err)
 	  }
 	  writeFile(fmt.Sprintf("conf/certs/ca%s.key", n), caPrivateKeyBlockByte1)
 	  n = "4"
 	  log.Printf("generate caPrivateKey%s ", n)
-	  caPrivateKey2, caPrivateKeyBlockByte2, err := generatePrivateKey()
+	  caPrivateKey2, caPrivateKeyBlockByte2, err := pki.GeneratePrivateKey(rand.Reader, pki.DefaultKeyAlgorithm, output.KeyPassword)
 	  if err != nil {
 	      log.Fatalf("get caPrivateKey%s Block failed, error %v", n, err)
 	  }
@@ -358,7 +379,7 @@ func main() {
 	  writeFile(fmt.Sprintf("conf/certs/ca%s.crt", "34"), crossCertBytes)
 
 	  log.Printf("generate serverPrivateKey%s ", n)
-	  serverPrivateKey1, serverPrivateKeyBlockByte1, err := generatePrivateKey()
+	  serverPrivateKey1, serverPrivateKeyBlockByte1, err := pki.GeneratePrivateKey(rand.Reader, pki.DefaultKeyAlgorithm, output.KeyPassword)
 	  if err != nil {
 	      log.Fatalf("get serverPrivateKey%s Block failed, error %v", n, err)
 	  }
@@ -370,11 +391,11 @@ func main() {
 	      log.Fatalf("get serverCsr%s failed, error %v",n, err)
 	  }
 	  log.Printf("generate serverCert%s ", n)
-	  serverCertBytes1 := SignServerCert(serverCsrBlockBytes1, crossCertBytes, caPrivateKey2)
+	  serverCertBytes1 := SignServerCert(serverCsrBlockBytes1, crossCertBytes, caPrivateKey2, pki.CertOptions{})
 	  writeFile(fmt.Sprintf("conf/certs/server%s.crt", n), serverCertBytes1)
 
 	  log.Printf("generate clientPrivateKey%s ", n)
-	  clientPrivateKey1, clientPrivateKeyBlockByte1, err := generatePrivateKey()
+	  clientPrivateKey1, clientPrivateKeyBlockByte1, err := pki.GeneratePrivateKey(rand.Reader, pki.DefaultKeyAlgorithm, output.KeyPassword)
 	  if err != nil {
 	      log.Fatalf("get clientPrivateKey%s Block failed, error %v", n, err)
 	  }
@@ -386,8 +407,34 @@ func main() {
 	      log.Fatalf("get serverCsr%s failed, error %v", n, err)
 	  }
 	  log.Printf("generate clientCert%s ", n)
-	  clientCertBytes1 := SignServerCert(clientCsrBlockBytes1, crossCertBytes, caPrivateKey2)
+	  clientCertBytes1 := SignServerCert(clientCsrBlockBytes1, crossCertBytes, caPrivateKey2, pki.CertOptions{})
 	  writeFile(fmt.Sprintf("conf/certs/client%s.crt", n), clientCertBytes1)
 	*/
-	generateCrossCert()
+	generateCrossCert(output, names)
+}
+
+var commands = map[string]func(args []string){
+	"generate":    runGenerate,
+	"keystore":    runKeystore,
+	"revoke":      runRevoke,
+	"crl":         runCRL,
+	"ocsp-signer": runOCSPSigner,
+	"inventory":   runInventory,
+	"sign":        runSign,
+	"expiry":      runExpiry,
+	"inspect":     runInspect,
+	"verify":      runVerify,
+	"graph":       runGraph,
+	"mesh":        runMesh,
+	"ceremony":    runCeremony,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+	runGenerate(os.Args[1:])
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+// GraphNode is one certificate of the trust graph.
+type GraphNode struct {
+	File string
+	Cert *x509.Certificate
+	// Kind is root, cross, intermediate or leaf. Cross certificates carry
+	// the subject and key of a CA found elsewhere in the directory.
+	Kind    string
+	Expired bool
+	Revoked bool
+}
+
+func (n *GraphNode) label() string {
+	parts := []string{n.Cert.Subject.CommonName, filepath.Base(n.File), n.Kind}
+	if n.Expired {
+		parts = append(parts, "expired")
+	}
+	if n.Revoked {
+		parts = append(parts, "revoked")
+	}
+	return strings.Join(parts, "\n")
+}
+
+// TrustGraph links each certificate to the certificates it signed.
+type TrustGraph struct {
+	Nodes []*GraphNode
+	Edges []GraphEdge
+}
+
+// GraphEdge says Nodes[From] signed Nodes[To].
+type GraphEdge struct {
+	From, To int
+}
+
+// signedBy reports whether issuer signed cert: key identifiers must agree
+// when both are present, names otherwise, and the signature must verify.
+func signedBy(cert, issuer *x509.Certificate) bool {
+	if len(cert.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 {
+		if !bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId) {
+			return false
+		}
+	} else if !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+		return false
+	}
+	return cert.CheckSignatureFrom(issuer) == nil
+}
+
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// revokedSerials collects the revoked serials of every <ca>.index.txt in
+// dir, keyed by the CA certificate the database belongs to.
+func revokedSerials(dir string) (map[string]map[string]bool, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.index.txt"))
+	if err != nil {
+		return nil, err
+	}
+	revoked := map[string]map[string]bool{}
+	for _, path := range paths {
+		entries, err := utils.ReadIndex(path)
+		if err != nil {
+			return nil, err
+		}
+		caCertPath := strings.TrimSuffix(path, ".index.txt") + ".crt"
+		for _, entry := range entries {
+			if entry.Status != "R" {
+				continue
+			}
+			if revoked[caCertPath] == nil {
+				revoked[caCertPath] = map[string]bool{}
+			}
+			revoked[caCertPath][utils.FormatSerial(entry.Serial)] = true
+		}
+	}
+	return revoked, nil
+}
+
+// buildTrustGraph reads every *.crt in dir. Revocations come from the CA
+// databases next to them, expiry is judged at now.
+func buildTrustGraph(dir string, now time.Time) (*TrustGraph, error) {
+	certs, err := readChainCerts([]string{filepath.Join(dir, "*.crt")})
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := revokedSerials(dir)
+	if err != nil {
+		return nil, err
+	}
+	graph := &TrustGraph{}
+	for _, c := range certs {
+		graph.Nodes = append(graph.Nodes, &GraphNode{File: c.file, Cert: c.cert, Expired: now.After(c.cert.NotAfter)})
+	}
+	for i, node := range graph.Nodes {
+		cert := node.Cert
+		switch {
+		case !cert.IsCA:
+			node.Kind = "leaf"
+		case selfSigned(cert):
+			node.Kind = "root"
+		default:
+			node.Kind = "intermediate"
+			for j, other := range graph.Nodes {
+				if i != j && sameSubjectKey(other.Cert, cert) {
+					node.Kind = "cross"
+				}
+			}
+		}
+		for j, issuer := range graph.Nodes {
+			// a root and its cross certificates share a key, each verifies
+			// the signature of the others but does not issue them
+			if i == j || !issuer.Cert.IsCA || sameSubjectKey(cert, issuer.Cert) || !signedBy(cert, issuer.Cert) {
+				continue
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{From: j, To: i})
+			if revoked[issuer.File][utils.FormatSerial(cert.SerialNumber)] {
+				node.Revoked = true
+			}
+		}
+	}
+	return graph, nil
+}
+
+var dotStyles = map[string]string{
+	"root":         `shape=doubleoctagon, style=filled, fillcolor="#cfe2f3"`,
+	"cross":        `shape=box, style="dashed,rounded"`,
+	"intermediate": `shape=box, style=rounded`,
+	"leaf":         `shape=ellipse`,
+}
+
+func writeDOT(w io.Writer, graph *TrustGraph) error {
+	fmt.Fprintln(w, "digraph trust {")
+	fmt.Fprintln(w, "  rankdir=TB;")
+	for i, node := range graph.Nodes {
+		style := dotStyles[node.Kind]
+		switch {
+		case node.Revoked:
+			style += `, color="#cc0000", fontcolor="#cc0000", penwidth=2`
+		case node.Expired:
+			style += `, color="#999999", fontcolor="#999999"`
+		}
+		fmt.Fprintf(w, "  n%d [label=%q, %s];\n", i, node.label(), style)
+	}
+	for _, edge := range graph.Edges {
+		attributes := ""
+		if graph.Nodes[edge.To].Kind == "cross" {
+			attributes = " [style=dashed]"
+		}
+		fmt.Fprintf(w, "  n%d -> n%d%s;\n", edge.From, edge.To, attributes)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func writeMermaid(w io.Writer, graph *TrustGraph) error {
+	fmt.Fprintln(w, "graph TD")
+	for i, node := range graph.Nodes {
+		label := strings.ReplaceAll(strings.ReplaceAll(node.label(), `"`, "#quot;"), "\n", "<br/>")
+		if node.Kind == "leaf" {
+			fmt.Fprintf(w, "  n%d([\"%s\"])\n", i, label)
+		} else {
+			fmt.Fprintf(w, "  n%d[\"%s\"]\n", i, label)
+		}
+	}
+	for _, edge := range graph.Edges {
+		arrow := "-->"
+		if graph.Nodes[edge.To].Kind == "cross" {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(w, "  n%d %s n%d\n", edge.From, arrow, edge.To)
+	}
+	for i, node := range graph.Nodes {
+		fmt.Fprintf(w, "  class n%d %s\n", i, node.Kind)
+		if node.Expired {
+			fmt.Fprintf(w, "  class n%d expired\n", i)
+		}
+		if node.Revoked {
+			fmt.Fprintf(w, "  class n%d revoked\n", i)
+		}
+	}
+	fmt.Fprintln(w, "  classDef root fill:#cfe2f3,stroke:#0b5394")
+	fmt.Fprintln(w, "  classDef cross stroke-dasharray:5 5")
+	fmt.Fprintln(w, "  classDef intermediate fill:#fff")
+	fmt.Fprintln(w, "  classDef leaf fill:#d9ead3")
+	fmt.Fprintln(w, "  classDef expired fill:#eee,color:#999")
+	_, err := fmt.Fprintln(w, "  classDef revoked fill:#f4cccc,stroke:#c00,color:#c00")
+	return err
+}
+
+// runGraph exports the trust graph of a certificate directory for Graphviz
+// (dot -Tsvg) or Mermaid.
+func runGraph(args []string) {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	dir := flags.String("dir", "conf/certs", "directory of PEM certificates and <ca>.index.txt databases")
+	format := flags.String("format", "dot", "output format: dot or mermaid")
+	out := flags.String("out", "", "write the graph to this file instead of stdout")
+	flags.Parse(args)
+	graph, err := buildTrustGraph(*dir, time.Now())
+	if err != nil {
+		log.Fatalf("build trust graph of %q failed, error %v", *dir, err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("create %q failed, error %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "dot":
+		err = writeDOT(w, graph)
+	case "mermaid":
+		err = writeMermaid(w, graph)
+	default:
+		err = fmt.Errorf("unsupported format %q, want dot or mermaid", *format)
+	}
+	if err != nil {
+		log.Fatalf("write graph failed, error %v", err)
+	}
+}
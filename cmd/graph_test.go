@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+)
+
+func TestTrustGraph(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:     dir,
+		KeyAlgorithm:  "ecdsa-p256",
+		CAs:           []CASpec{{Name: "ca0"}, {Name: "ca1"}},
+		CrossSigns:    []CrossSignSpec{{Name: "ca01", Issuer: "ca0", Subject: "ca1"}},
+		Intermediates: []IntermediateSpec{{Name: "sub", Issuer: "ca1"}},
+		Leaves:        []LeafSpec{{Name: "server1", Issuer: "sub"}, {Name: "client1", Issuer: "ca1", ValidityDays: 1}},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	client := readCert(t, filepath.Join(dir, "client1.crt"))
+	caCertPath := filepath.Join(dir, "ca1.crt")
+	if err := pki.RevokeCertificate(caCertPath, readCert(t, caCertPath), client.SerialNumber, client, "keyCompromise", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := buildTrustGraph(dir, time.Now().AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := map[string]*GraphNode{}
+	index := map[string]int{}
+	for i, node := range graph.Nodes {
+		nodes[filepath.Base(node.File)] = node
+		index[filepath.Base(node.File)] = i
+	}
+	for file, kind := range map[string]string{"ca0.crt": "root", "ca1.crt": "root", "ca01.crt": "cross", "sub.crt": "intermediate", "server1.crt": "leaf"} {
+		if nodes[file] == nil || nodes[file].Kind != kind {
+			t.Errorf("%s is %+v, want kind %s", file, nodes[file], kind)
+		}
+	}
+	if !nodes["client1.crt"].Revoked || !nodes["client1.crt"].Expired || nodes["server1.crt"].Revoked || nodes["server1.crt"].Expired {
+		t.Errorf("client1 %+v, server1 %+v", nodes["client1.crt"], nodes["server1.crt"])
+	}
+	edges := map[GraphEdge]bool{}
+	for _, edge := range graph.Edges {
+		edges[edge] = true
+	}
+	// sub is signed with the key of ca1 and so hangs below the root and
+	// the cross certificate, a root is never below its own cross certificate
+	for _, edge := range [][2]string{{"ca0.crt", "ca01.crt"}, {"ca1.crt", "sub.crt"}, {"ca01.crt", "sub.crt"}, {"sub.crt", "server1.crt"}} {
+		if !edges[GraphEdge{From: index[edge[0]], To: index[edge[1]]}] {
+			t.Errorf("missing edge %s -> %s", edge[0], edge[1])
+		}
+	}
+	if edges[GraphEdge{From: index["ca01.crt"], To: index["ca1.crt"]}] {
+		t.Error("unexpected edge ca01.crt -> ca1.crt")
+	}
+
+	dot, mermaid := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := writeDOT(dot, graph); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMermaid(mermaid, graph); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dot.String(), "digraph trust {") || !strings.Contains(dot.String(), "revoked") {
+		t.Errorf("dot output is %s", dot)
+	}
+	if !strings.HasPrefix(mermaid.String(), "graph TD") || !strings.Contains(mermaid.String(), fmt.Sprintf("class n%d revoked", index["client1.crt"])) {
+		t.Errorf("mermaid output is %s", mermaid)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+const (
+	kindCertificate = "certificate"
+	kindCSR         = "certificate request"
+	kindPrivateKey  = "private key"
+)
+
+// Inspection describes one certificate, CSR or private key found by inspect.
+// PublicKeySHA256 is the SHA-256 of the SubjectPublicKeyInfo, equal for a
+// key and every certificate or CSR made for it.
+type Inspection struct {
+	Source                string     `json:"source"`
+	Kind                  string     `json:"kind"`
+	Subject               string     `json:"subject,omitempty"`
+	Issuer                string     `json:"issuer,omitempty"`
+	Serial                string     `json:"serial,omitempty"`
+	NotBefore             *time.Time `json:"notBefore,omitempty"`
+	NotAfter              *time.Time `json:"notAfter,omitempty"`
+	IsCA                  bool       `json:"isCA,omitempty"`
+	MaxPathLen            *int       `json:"maxPathLen,omitempty"`
+	DNSNames              []string   `json:"dnsNames,omitempty"`
+	IPAddresses           []string   `json:"ipAddresses,omitempty"`
+	EmailAddresses        []string   `json:"emailAddresses,omitempty"`
+	URIs                  []string   `json:"uris,omitempty"`
+	KeyUsage              []string   `json:"keyUsage,omitempty"`
+	ExtKeyUsage           []string   `json:"extKeyUsage,omitempty"`
+	SubjectKeyID          string     `json:"subjectKeyId,omitempty"`
+	AuthorityKeyID        string     `json:"authorityKeyId,omitempty"`
+	CRLDistributionPoints []string   `json:"crlDistributionPoints,omitempty"`
+	OCSPServers           []string   `json:"ocspServers,omitempty"`
+	SignatureAlgorithm    string     `json:"signatureAlgorithm,omitempty"`
+	PublicKeyAlgorithm    string     `json:"publicKeyAlgorithm,omitempty"`
+	PublicKeySHA256       string     `json:"publicKeySHA256,omitempty"`
+	SHA1Fingerprint       string     `json:"sha1Fingerprint,omitempty"`
+	SHA256Fingerprint     string     `json:"sha256Fingerprint,omitempty"`
+	Error                 string     `json:"error,omitempty"`
+	// chained marks certificates after the first of a file, the issuers of
+	// a chain file, which need no key of their own.
+	chained bool
+}
+
+// Pairing is the result of matching keys, certificates and CSRs by their
+// public key. Unpaired lists what matched nothing of another kind although
+// the input had some.
+type Pairing struct {
+	Pairs    [][2]string `json:"pairs"`
+	Unpaired []string    `json:"unpaired"`
+}
+
+// InspectReport is what inspect prints, as text or JSON.
+type InspectReport struct {
+	Objects []*Inspection `json:"objects"`
+	Pairing Pairing       `json:"pairing"`
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+func keyUsageList(usage x509.KeyUsage) []string {
+	var names []string
+	for _, u := range keyUsageNames {
+		if usage&u.usage != 0 {
+			names = append(names, u.name)
+		}
+	}
+	return names
+}
+
+func extKeyUsageList(usages []x509.ExtKeyUsage) []string {
+	names := make([]string, 0, len(usages))
+	for _, usage := range usages {
+		name, ok := extKeyUsageNames[usage]
+		if !ok {
+			name = fmt.Sprintf("unknown(%d)", usage)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// colonHex formats b like openssl, upper case bytes separated by colons.
+func colonHex(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
+
+func describePublicKey(publicKey crypto.PublicKey) (algorithm, fingerprint string) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		algorithm = fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		algorithm = "ECDSA " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		algorithm = "Ed25519"
+	default:
+		algorithm = fmt.Sprintf("%T", publicKey)
+	}
+	if der, err := x509.MarshalPKIXPublicKey(publicKey); err == nil {
+		sum := sha256.Sum256(der)
+		fingerprint = hex.EncodeToString(sum[:])
+	}
+	return algorithm, fingerprint
+}
+
+func inspectCertificate(source string, cert *x509.Certificate) *Inspection {
+	sha1Sum, sha256Sum := sha1.Sum(cert.Raw), sha256.Sum256(cert.Raw)
+	i := &Inspection{
+		Source:                source,
+		Kind:                  kindCertificate,
+		Subject:               cert.Subject.String(),
+		Issuer:                cert.Issuer.String(),
+		Serial:                utils.FormatSerial(cert.SerialNumber),
+		NotBefore:             &cert.NotBefore,
+		NotAfter:              &cert.NotAfter,
+		IsCA:                  cert.IsCA,
+		DNSNames:              cert.DNSNames,
+		EmailAddresses:        cert.EmailAddresses,
+		KeyUsage:              keyUsageList(cert.KeyUsage),
+		ExtKeyUsage:           extKeyUsageList(cert.ExtKeyUsage),
+		SubjectKeyID:          colonHex(cert.SubjectKeyId),
+		AuthorityKeyID:        colonHex(cert.AuthorityKeyId),
+		CRLDistributionPoints: cert.CRLDistributionPoints,
+		OCSPServers:           cert.OCSPServer,
+		SignatureAlgorithm:    cert.SignatureAlgorithm.String(),
+		SHA1Fingerprint:       colonHex(sha1Sum[:]),
+		SHA256Fingerprint:     colonHex(sha256Sum[:]),
+	}
+	if cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		maxPathLen := cert.MaxPathLen
+		i.MaxPathLen = &maxPathLen
+	}
+	for _, ip := range cert.IPAddresses {
+		i.IPAddresses = append(i.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		i.URIs = append(i.URIs, uri.String())
+	}
+	i.PublicKeyAlgorithm, i.PublicKeySHA256 = describePublicKey(cert.PublicKey)
+	return i
+}
+
+func inspectCSR(source string, csr *x509.CertificateRequest) *Inspection {
+	i := &Inspection{
+		Source:             source,
+		Kind:               kindCSR,
+		Subject:            csr.Subject.String(),
+		DNSNames:           csr.DNSNames,
+		EmailAddresses:     csr.EmailAddresses,
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+	}
+	for _, ip := range csr.IPAddresses {
+		i.IPAddresses = append(i.IPAddresses, ip.String())
+	}
+	for _, uri := range csr.URIs {
+		i.URIs = append(i.URIs, uri.String())
+	}
+	if err := csr.CheckSignature(); err != nil {
+		i.Error = fmt.Sprintf("csr signature is invalid, %v", err)
+	}
+	i.PublicKeyAlgorithm, i.PublicKeySHA256 = describePublicKey(csr.PublicKey)
+	return i
+}
+
+func inspectPrivateKey(source string, keyPEM []byte, password string) *Inspection {
+	i := &Inspection{Source: source, Kind: kindPrivateKey}
+	key, err := utils.DecodePrivateKey(keyPEM, password)
+	if err != nil {
+		i.Error = err.Error()
+		return i
+	}
+	i.PublicKeyAlgorithm, i.PublicKeySHA256 = describePublicKey(key.Public())
+	return i
+}
+
+// inspectFile describes every PEM block of path, or the whole file when it
+// is a DER certificate, CSR or PKCS#8 key. Objects are named path#n when
+// the file holds more than one.
+func inspectFile(path, password string) ([]*Inspection, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inspections []*Inspection
+	rest := content
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		source := fmt.Sprintf("%s#%d", path, len(inspections)+1)
+		switch {
+		case block.Type == "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				inspections = append(inspections, &Inspection{Source: source, Kind: kindCertificate, Error: err.Error()})
+				continue
+			}
+			i := inspectCertificate(source, cert)
+			for _, previous := range inspections {
+				if previous.Kind == kindCertificate {
+					i.chained = true
+				}
+			}
+			inspections = append(inspections, i)
+		case block.Type == "CERTIFICATE REQUEST" || block.Type == "NEW CERTIFICATE REQUEST":
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				inspections = append(inspections, &Inspection{Source: source, Kind: kindCSR, Error: err.Error()})
+				continue
+			}
+			inspections = append(inspections, inspectCSR(source, csr))
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			inspections = append(inspections, inspectPrivateKey(source, pem.EncodeToMemory(block), password))
+		}
+	}
+	if len(inspections) == 0 {
+		if cert, err := x509.ParseCertificate(content); err == nil {
+			return []*Inspection{inspectCertificate(path, cert)}, nil
+		}
+		if csr, err := x509.ParseCertificateRequest(content); err == nil {
+			return []*Inspection{inspectCSR(path, csr)}, nil
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(content); err == nil {
+			return []*Inspection{inspectPrivateKey(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: content}), "")}, nil
+		}
+		return nil, fmt.Errorf("%q holds no certificate, certificate request or private key", path)
+	}
+	if len(inspections) == 1 {
+		inspections[0].Source = path
+	}
+	return inspections, nil
+}
+
+// pairObjects matches objects of different kinds sharing a public key, the
+// modulus | md5 comparison of openssl_cmd.md for every key type.
+func pairObjects(objects []*Inspection) Pairing {
+	pairing := Pairing{Pairs: [][2]string{}, Unpaired: []string{}}
+	kinds := map[string]bool{}
+	for _, o := range objects {
+		if !o.chained {
+			kinds[o.Kind] = true
+		}
+	}
+	for i, a := range objects {
+		paired := false
+		for j, b := range objects {
+			if i == j || a.Kind == b.Kind || a.PublicKeySHA256 == "" || a.PublicKeySHA256 != b.PublicKeySHA256 {
+				continue
+			}
+			paired = true
+			if i < j {
+				pairing.Pairs = append(pairing.Pairs, [2]string{a.Source, b.Source})
+			}
+		}
+		if !paired && !a.chained && len(kinds) > 1 {
+			pairing.Unpaired = append(pairing.Unpaired, a.Source)
+		}
+	}
+	return pairing
+}
+
+func printInspection(w io.Writer, i *Inspection) {
+	field := func(name string, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "  %-24s %s\n", name+":", value)
+		}
+	}
+	list := func(name string, values []string) {
+		field(name, strings.Join(values, ", "))
+	}
+	fmt.Fprintf(w, "%s (%s)\n", i.Source, i.Kind)
+	field("Error", i.Error)
+	field("Subject", i.Subject)
+	field("Issuer", i.Issuer)
+	field("Serial", i.Serial)
+	if i.NotBefore != nil {
+		field("Not Before", i.NotBefore.Format(time.RFC3339))
+		field("Not After", i.NotAfter.Format(time.RFC3339))
+	}
+	if i.IsCA {
+		pathLen := "unlimited"
+		if i.MaxPathLen != nil {
+			pathLen = fmt.Sprint(*i.MaxPathLen)
+		}
+		field("CA", "true, path length "+pathLen)
+	}
+	list("DNS Names", i.DNSNames)
+	list("IP Addresses", i.IPAddresses)
+	list("Email Addresses", i.EmailAddresses)
+	list("URIs", i.URIs)
+	list("Key Usage", i.KeyUsage)
+	list("Extended Key Usage", i.ExtKeyUsage)
+	field("Subject Key ID", i.SubjectKeyID)
+	field("Authority Key ID", i.AuthorityKeyID)
+	list("CRL Distribution Points", i.CRLDistributionPoints)
+	list("OCSP Servers", i.OCSPServers)
+	field("Signature Algorithm", i.SignatureAlgorithm)
+	field("Public Key", i.PublicKeyAlgorithm)
+	field("Public Key SHA-256", i.PublicKeySHA256)
+	field("SHA-1 Fingerprint", i.SHA1Fingerprint)
+	field("SHA-256 Fingerprint", i.SHA256Fingerprint)
+}
+
+func writeInspectReport(w io.Writer, report *InspectReport, format string) error {
+	switch format {
+	case "", "text":
+		for _, i := range report.Objects {
+			printInspection(w, i)
+			fmt.Fprintln(w)
+		}
+		for _, pair := range report.Pairing.Pairs {
+			fmt.Fprintf(w, "match: %s <-> %s\n", pair[0], pair[1])
+		}
+		for _, source := range report.Pairing.Unpaired {
+			fmt.Fprintf(w, "MISMATCH: %s matches no other key, certificate or request\n", source)
+		}
+		return nil
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	default:
+		return fmt.Errorf("unsupported format %q, want text or json", format)
+	}
+}
+
+// runInspect prints certificates, CSRs and keys and checks that the keys,
+// certificates and requests given together belong to each other. It exits
+// with status 1 when something does not pair up.
+func runInspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	format := flags.String("format", "text", "output format: text or json")
+	keyPasswordValue := flags.String("key-password", "", "password of encrypted private keys")
+	keyPasswordFile := flags.String("key-password-file", "", "file holding the password of encrypted private keys")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatalf("usage: inspect [flags] file...")
+	}
+	password, err := utils.ResolvePassword(*keyPasswordValue, *keyPasswordFile, "CERT_KEY_PASSWORD")
+	if err != nil {
+		log.Fatalf("resolve key password failed, error %v", err)
+	}
+	report := &InspectReport{}
+	for _, path := range flags.Args() {
+		inspections, err := inspectFile(path, password)
+		if err != nil {
+			log.Fatalf("inspect %q failed, error %v", path, err)
+		}
+		report.Objects = append(report.Objects, inspections...)
+	}
+	report.Pairing = pairObjects(report.Objects)
+	if err := writeInspectReport(os.Stdout, report, *format); err != nil {
+		log.Fatalf("write report failed, error %v", err)
+	}
+	if len(report.Pairing.Unpaired) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectPairing(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p256",
+		CAs:          []CASpec{{Name: "ca1"}},
+		Leaves:       []LeafSpec{{Name: "server1", Issuer: "ca1", IPAddresses: []string{"127.0.0.1"}}},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	inspect := func(names ...string) []*Inspection {
+		var objects []*Inspection
+		for _, name := range names {
+			inspections, err := inspectFile(filepath.Join(dir, name), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			objects = append(objects, inspections...)
+		}
+		return objects
+	}
+
+	objects := inspect("server1.crt", "server1.key")
+	cert := objects[0]
+	if cert.Kind != kindCertificate || cert.Subject != "CN=server1" || len(cert.IPAddresses) != 1 || cert.IPAddresses[0] != "127.0.0.1" {
+		t.Errorf("certificate inspection is %+v", cert)
+	}
+	if cert.SubjectKeyID == "" || cert.AuthorityKeyID == "" || len(cert.ExtKeyUsage) != 2 {
+		t.Errorf("certificate key ids %q %q, ext key usage %v", cert.SubjectKeyID, cert.AuthorityKeyID, cert.ExtKeyUsage)
+	}
+	if pairing := pairObjects(objects); len(pairing.Pairs) != 1 || len(pairing.Unpaired) != 0 {
+		t.Errorf("matching key and cert paired as %+v", pairing)
+	}
+	if pairing := pairObjects(inspect("server1.crt", "ca1.key")); len(pairing.Unpaired) != 2 {
+		t.Errorf("mismatched key and cert paired as %+v", pairing)
+	}
+
+	// a chain file pairs by its first certificate, the issuers need no key
+	server, _ := os.ReadFile(filepath.Join(dir, "server1.crt"))
+	ca, _ := os.ReadFile(filepath.Join(dir, "ca1.crt"))
+	if err := os.WriteFile(filepath.Join(dir, "chain.pem"), append(server, ca...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	objects = inspect("chain.pem", "server1.key")
+	if len(objects) != 3 || !objects[1].chained || !objects[1].IsCA {
+		t.Fatalf("chain inspection is %+v", objects)
+	}
+	if pairing := pairObjects(objects); len(pairing.Pairs) != 1 || len(pairing.Unpaired) != 0 {
+		t.Errorf("chain and key paired as %+v", pairing)
+	}
+}
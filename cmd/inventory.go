@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+// InventoryRecord is one issued certificate as listed and exported by the
+// inventory command.
+type InventoryRecord struct {
+	CA             string     `json:"ca"`
+	Status         string     `json:"status"`
+	Serial         string     `json:"serial"`
+	Subject        string     `json:"subject"`
+	Expiry         time.Time  `json:"expiry"`
+	RevocationTime *time.Time `json:"revocationTime,omitempty"`
+	Reason         string     `json:"reason,omitempty"`
+	File           string     `json:"file"`
+}
+
+// loadInventory reads every <ca>.index.txt under dirs. Valid certificates
+// past their expiry are reported with status E, like openssl ca -updatedb.
+func loadInventory(dirs []string, now time.Time) ([]InventoryRecord, error) {
+	var records []InventoryRecord
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.index.txt"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			entries, err := utils.ReadIndex(path)
+			if err != nil {
+				return nil, err
+			}
+			ca := strings.TrimSuffix(filepath.Base(path), ".index.txt")
+			for _, entry := range entries {
+				record := InventoryRecord{
+					CA:      ca,
+					Status:  entry.Status,
+					Serial:  utils.FormatSerial(entry.Serial),
+					Subject: entry.Subject,
+					Expiry:  entry.Expiry,
+					Reason:  entry.Reason,
+					File:    entry.File,
+				}
+				if entry.Status == "R" {
+					revocationTime := entry.RevocationTime
+					record.RevocationTime = &revocationTime
+				} else if entry.Expiry.Before(now) {
+					record.Status = "E"
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].CA != records[j].CA {
+			return records[i].CA < records[j].CA
+		}
+		return records[i].Expiry.Before(records[j].Expiry)
+	})
+	return records, nil
+}
+
+// InventoryFilter selects records, empty fields match everything.
+type InventoryFilter struct {
+	CA            string
+	Status        string
+	Serial        string
+	Subject       string
+	ExpiresBefore time.Time
+}
+
+func (f InventoryFilter) empty() bool {
+	return f == InventoryFilter{}
+}
+
+func (f InventoryFilter) match(record InventoryRecord) bool {
+	if f.CA != "" && record.CA != f.CA {
+		return false
+	}
+	if f.Status != "" && !strings.EqualFold(record.Status, f.Status) {
+		return false
+	}
+	if f.Serial != "" {
+		serial, err := utils.ParseSerial(f.Serial)
+		if err != nil || utils.FormatSerial(serial) != record.Serial {
+			return false
+		}
+	}
+	if f.Subject != "" && !strings.Contains(strings.ToLower(record.Subject), strings.ToLower(f.Subject)) {
+		return false
+	}
+	if !f.ExpiresBefore.IsZero() && !record.Expiry.Before(f.ExpiresBefore) {
+		return false
+	}
+	return true
+}
+
+func filterInventory(records []InventoryRecord, filter InventoryFilter) []InventoryRecord {
+	var matched []InventoryRecord
+	for _, record := range records {
+		if filter.match(record) {
+			matched = append(matched, record)
+		}
+	}
+	return matched
+}
+
+func writeInventory(w io.Writer, records []InventoryRecord, format string) error {
+	switch format {
+	case "", "table":
+		table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "CA\tSTATUS\tSERIAL\tEXPIRY\tREVOKED\tFILE\tSUBJECT")
+		for _, record := range records {
+			revoked := "-"
+			if record.RevocationTime != nil {
+				revoked = record.RevocationTime.Format(time.RFC3339)
+				if record.Reason != "" {
+					revoked += " " + record.Reason
+				}
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", record.CA, record.Status, record.Serial, record.Expiry.Format(time.RFC3339), revoked, record.File, record.Subject)
+		}
+		return table.Flush()
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"ca", "status", "serial", "subject", "expiry", "revocationTime", "reason", "file"})
+		for _, record := range records {
+			revoked := ""
+			if record.RevocationTime != nil {
+				revoked = record.RevocationTime.Format(time.RFC3339)
+			}
+			writer.Write([]string{record.CA, record.Status, record.Serial, record.Subject, record.Expiry.Format(time.RFC3339), revoked, record.Reason, record.File})
+		}
+		writer.Flush()
+		return writer.Error()
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if records == nil {
+			records = []InventoryRecord{}
+		}
+		return encoder.Encode(records)
+	default:
+		return fmt.Errorf("unsupported format %q, want table, csv or json", format)
+	}
+}
+
+// runInventory lists, searches or exports the certificates recorded in the
+// CA databases: inventory list|search|export [flags].
+func runInventory(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("usage: inventory list|search|export [flags]")
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("inventory "+action, flag.ExitOnError)
+	dirs := flags.String("dir", "conf/certs", "comma separated directories holding <ca>.index.txt databases")
+	ca := flags.String("ca", "", "only certificates issued by this CA, e.g. ca1")
+	status := flags.String("status", "", "only certificates with this status, V (valid), R (revoked) or E (expired)")
+	serial := flags.String("serial", "", "only the certificate with this hexadecimal serial")
+	subject := flags.String("subject", "", "only certificates whose subject contains this text")
+	expiresWithin := flags.Duration("expires-within", 0, "only certificates expiring within this duration, e.g. 720h")
+	format := flags.String("format", "", "output format: table, csv or json, export defaults to csv")
+	out := flags.String("out", "", "write the export to this file instead of stdout")
+	flags.Parse(args[1:])
+
+	now := time.Now()
+	filter := InventoryFilter{CA: *ca, Status: *status, Serial: *serial, Subject: *subject}
+	if *expiresWithin > 0 {
+		filter.ExpiresBefore = now.Add(*expiresWithin)
+	}
+	switch action {
+	case "list":
+	case "search":
+		if filter.empty() {
+			log.Fatalf("search needs at least one of -ca, -status, -serial, -subject or -expires-within")
+		}
+	case "export":
+		if *format == "" {
+			*format = "csv"
+		}
+	default:
+		log.Fatalf("unknown inventory action %q, want list, search or export", action)
+	}
+	records, err := loadInventory(strings.Split(*dirs, ","), now)
+	if err != nil {
+		log.Fatalf("load inventory failed, error %v", err)
+	}
+	records = filterInventory(records, filter)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("create %q failed, error %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeInventory(w, records, *format); err != nil {
+		log.Fatalf("write inventory failed, error %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+)
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p256",
+		CAs:          []CASpec{{Name: "ca0"}, {Name: "ca1"}},
+		CrossSigns:   []CrossSignSpec{{Name: "ca01", Issuer: "ca0", Subject: "ca1"}},
+		Leaves:       []LeafSpec{{Name: "server1", Issuer: "ca1"}, {Name: "client1", Issuer: "ca01", ValidityDays: 10}},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	records, err := loadInventory([]string{dir}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, record := range records {
+		files[filepath.Base(record.File)] = record.CA
+	}
+	// leaves of the cross signed issuer share the database of ca1
+	want := map[string]string{"ca0.crt": "ca0", "ca01.crt": "ca0", "ca1.crt": "ca1", "server1.crt": "ca1", "client1.crt": "ca1"}
+	for file, ca := range want {
+		if files[file] != ca {
+			t.Errorf("%s is recorded under %q, want %q", file, files[file], ca)
+		}
+	}
+
+	client := readCert(t, filepath.Join(dir, "client1.crt"))
+	caCertPath := filepath.Join(dir, "ca1.crt")
+	if err := pki.RevokeCertificate(caCertPath, readCert(t, caCertPath), client.SerialNumber, client, "superseded", now); err != nil {
+		t.Fatal(err)
+	}
+	if records, err = loadInventory([]string{dir}, now); err != nil {
+		t.Fatal(err)
+	}
+	revoked := filterInventory(records, InventoryFilter{CA: "ca1", Status: "R"})
+	if len(revoked) != 1 || filepath.Base(revoked[0].File) != "client1.crt" || revoked[0].Reason != "superseded" {
+		t.Errorf("revoked records are %+v", revoked)
+	}
+	expiring := filterInventory(records, InventoryFilter{ExpiresBefore: now.AddDate(0, 0, 30)})
+	if len(expiring) != 1 || filepath.Base(expiring[0].File) != "client1.crt" {
+		t.Errorf("records expiring within 30 days are %+v", expiring)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := writeInventory(buffer, records, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var exported []InventoryRecord
+	if err := json.Unmarshal(buffer.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != len(records) {
+		t.Errorf("exported %d records, want %d", len(exported), len(records))
+	}
+}
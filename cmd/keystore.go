@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"gopkg.in/yaml.v3"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// KeystoreConfig lists the Java key and trust stores to build from PEM
+// files, replacing the keytool steps of openssl_cmd.md.
+type KeystoreConfig struct {
+	Stores []StoreSpec `json:"stores" yaml:"stores"`
+}
+
+// StoreSpec is one store file. A JKS store can hold any number of key and
+// certificate entries; a PKCS12 store is either one key with its chain or
+// a truststore of certificates only.
+type StoreSpec struct {
+	Path         string          `json:"path" yaml:"path"`
+	Type         string          `json:"type" yaml:"type"`
+	Password     string          `json:"password" yaml:"password"`
+	PasswordFile string          `json:"passwordFile" yaml:"passwordFile"`
+	KeyPassword  string          `json:"keyPassword" yaml:"keyPassword"`
+	Keys         []KeyEntrySpec  `json:"keys" yaml:"keys"`
+	Certs        []CertEntrySpec `json:"certs" yaml:"certs"`
+}
+
+type KeyEntrySpec struct {
+	Alias string   `json:"alias" yaml:"alias"`
+	Key   string   `json:"key" yaml:"key"`
+	Cert  string   `json:"cert" yaml:"cert"`
+	Chain []string `json:"chain" yaml:"chain"`
+}
+
+type CertEntrySpec struct {
+	Alias string `json:"alias" yaml:"alias"`
+	Cert  string `json:"cert" yaml:"cert"`
+}
+
+func loadKeystoreConfig(path string) (*KeystoreConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read keystore config %q failed, error %v", path, err)
+	}
+	config := &KeystoreConfig{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(content, config)
+	} else {
+		err = yaml.Unmarshal(content, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse keystore config %q failed, error %v", path, err)
+	}
+	return config, nil
+}
+
+func readCertFile(path string) (*x509.Certificate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := pki.ParseCertificate(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate %q failed, error %v", path, err)
+	}
+	return cert, nil
+}
+
+func readCertFiles(paths []string) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(paths))
+	for _, path := range paths {
+		cert, err := readCertFile(path)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// WriteStore builds the store described by spec. sourceKeyPassword decrypts
+// the PEM keys when they were generated with -key-password.
+func WriteStore(spec StoreSpec, sourceKeyPassword string) error {
+	password, err := utils.ResolvePassword(spec.Password, spec.PasswordFile, "")
+	if err != nil {
+		return err
+	}
+	var content []byte
+	switch strings.ToLower(spec.Type) {
+	case "", "jks":
+		content, err = encodeJKS(spec, password, sourceKeyPassword)
+	case "pkcs12", "p12":
+		content, err = encodePKCS12Store(spec, password, sourceKeyPassword)
+	default:
+		err = fmt.Errorf("unsupported store type %q, want jks or pkcs12", spec.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("build store %q failed, error %v", spec.Path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(spec.Path), 0700); err != nil {
+		return err
+	}
+	return writeFile(spec.Path, content)
+}
+
+func encodeJKS(spec StoreSpec, password, sourceKeyPassword string) ([]byte, error) {
+	keyPassword := spec.KeyPassword
+	if keyPassword == "" {
+		keyPassword = password
+	}
+	store := keystore.New(keystore.WithOrderedAliases())
+	now := time.Now()
+	for _, entry := range spec.Keys {
+		privateKey, err := utils.ReadPrivateKey(entry.Key, sourceKeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := readCertFiles(append([]string{entry.Cert}, entry.Chain...))
+		if err != nil {
+			return nil, err
+		}
+		chain := make([]keystore.Certificate, 0, len(certs))
+		for _, cert := range certs {
+			chain = append(chain, keystore.Certificate{Type: "X509", Content: cert.Raw})
+		}
+		privateKeyEntry := keystore.PrivateKeyEntry{CreationTime: now, PrivateKey: der, CertificateChain: chain}
+		if err := store.SetPrivateKeyEntry(entry.Alias, privateKeyEntry, []byte(keyPassword)); err != nil {
+			return nil, fmt.Errorf("add key %q failed, error %v", entry.Alias, err)
+		}
+	}
+	for _, entry := range spec.Certs {
+		cert, err := readCertFile(entry.Cert)
+		if err != nil {
+			return nil, err
+		}
+		trustedEntry := keystore.TrustedCertificateEntry{CreationTime: now, Certificate: keystore.Certificate{Type: "X509", Content: cert.Raw}}
+		if err := store.SetTrustedCertificateEntry(entry.Alias, trustedEntry); err != nil {
+			return nil, fmt.Errorf("add certificate %q failed, error %v", entry.Alias, err)
+		}
+	}
+	buffer := &bytes.Buffer{}
+	if err := store.Store(buffer, []byte(password)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func encodePKCS12Store(spec StoreSpec, password, sourceKeyPassword string) ([]byte, error) {
+	if len(spec.Keys) > 1 || (len(spec.Keys) == 1 && len(spec.Certs) > 0) {
+		return nil, fmt.Errorf("a pkcs12 store holds either one key or only trusted certificates")
+	}
+	if len(spec.Keys) == 1 {
+		entry := spec.Keys[0]
+		privateKey, err := utils.ReadPrivateKey(entry.Key, sourceKeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		certBlock, err := os.ReadFile(entry.Cert)
+		if err != nil {
+			return nil, err
+		}
+		chainBlocks := make([][]byte, 0, len(entry.Chain))
+		for _, path := range entry.Chain {
+			chainBlock, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			chainBlocks = append(chainBlocks, chainBlock)
+		}
+		return encodePKCS12(privateKey, certBlock, chainBlocks, password)
+	}
+	entries := make([]pkcs12.TrustStoreEntry, 0, len(spec.Certs))
+	for _, entry := range spec.Certs {
+		cert, err := readCertFile(entry.Cert)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, pkcs12.TrustStoreEntry{Cert: cert, FriendlyName: entry.Alias})
+	}
+	return pkcs12.Modern.EncodeTrustStoreEntries(entries, password)
+}
+
+func runKeystore(args []string) {
+	flags := flag.NewFlagSet("keystore", flag.ExitOnError)
+	configFile := flags.String("config", "conf/keystores.yaml", "YAML or JSON file listing the key and trust stores to write")
+	keyPasswordValue := flags.String("key-password", "", "password of encrypted PEM keys")
+	keyPasswordFile := flags.String("key-password-file", "", "file holding the password of encrypted PEM keys")
+	flags.Parse(args)
+	keyPassword, err := utils.ResolvePassword(*keyPasswordValue, *keyPasswordFile, "CERT_KEY_PASSWORD")
+	if err != nil {
+		log.Fatalf("resolve key password failed, error %v", err)
+	}
+	config, err := loadKeystoreConfig(*configFile)
+	if err != nil {
+		log.Fatalf("load keystore config failed, error %v", err)
+	}
+	for _, spec := range config.Stores {
+		log.Printf("write %s store %s", spec.Type, spec.Path)
+		if err := WriteStore(spec, keyPassword); err != nil {
+			log.Fatalf("write store failed, error %v", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+)
+
+func TestWriteStoreJKS(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p256",
+		CAs:          []CASpec{{Name: "ca1"}},
+		Leaves:       []LeafSpec{{Name: "server1", Issuer: "ca1"}},
+		Output:       OutputOptions{KeyPassword: "source"},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	spec := StoreSpec{
+		Path:        filepath.Join(dir, "server.keystore.jks"),
+		Password:    "123456",
+		KeyPassword: "654321",
+		Keys: []KeyEntrySpec{{
+			Alias: "server1",
+			Key:   filepath.Join(dir, "server1.key"),
+			Cert:  filepath.Join(dir, "server1.crt"),
+			Chain: []string{filepath.Join(dir, "ca1.crt")},
+		}},
+		Certs: []CertEntrySpec{{Alias: "caroot", Cert: filepath.Join(dir, "ca1.crt")}},
+	}
+	if err := WriteStore(spec, "source"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(spec.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	store := keystore.New()
+	if err := store.Load(f, []byte("123456")); err != nil {
+		t.Fatalf("load jks failed, error %v", err)
+	}
+	entry, err := store.GetPrivateKeyEntry("server1", []byte("654321"))
+	if err != nil {
+		t.Fatalf("get key entry failed, error %v", err)
+	}
+	if len(entry.CertificateChain) != 2 {
+		t.Errorf("key entry chain has %d certificates, want 2", len(entry.CertificateChain))
+	}
+	if !store.IsTrustedCertificateEntry("caroot") {
+		t.Error("caroot is not a trusted certificate entry")
+	}
+}
+
+func TestWriteStorePKCS12Rejects(t *testing.T) {
+	spec := StoreSpec{
+		Path:  filepath.Join(t.TempDir(), "mixed.p12"),
+		Type:  "pkcs12",
+		Keys:  []KeyEntrySpec{{Alias: "a"}, {Alias: "b"}},
+		Certs: []CertEntrySpec{{Alias: "c"}},
+	}
+	if err := WriteStore(spec, ""); err == nil {
+		t.Error("expected an error for a pkcs12 store with several keys")
+	}
+}
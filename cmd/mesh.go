@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"example.com/lx/beego/dev/pki"
+)
+
+// MeshSpec generates Count CAs named <prefix>1..<prefix>N cross-signed in
+// one of the layouts:
+//
+//	chain  each CA and its neighbours sign each other, ca1 <-> ca2 <-> ca3
+//	mesh   every CA signs every other CA
+//	hub    a bridge CA signs every CA and every CA signs the bridge
+//
+// Each CA issues one leaf per profile in LeafProfiles, a server and a
+// client leaf when unset. A cross certificate of issuer A for subject B is
+// named A-B.
+type MeshSpec struct {
+	Count        int      `json:"count" yaml:"count"`
+	Layout       string   `json:"layout" yaml:"layout"`
+	Prefix       string   `json:"prefix" yaml:"prefix"`
+	Bridge       string   `json:"bridge" yaml:"bridge"`
+	LeafProfiles []string `json:"leafProfiles" yaml:"leafProfiles"`
+	IPAddresses  []string `json:"ipAddresses" yaml:"ipAddresses"`
+}
+
+// meshLayouts lists the layouts a MeshSpec accepts.
+var meshLayouts = []string{"chain", "mesh", "hub"}
+
+// leafSuffix names the leaves of a mesh CA after their profile, server and
+// client for the TLS profiles.
+func leafSuffix(profile string) string {
+	return strings.TrimPrefix(profile, "tls-")
+}
+
+// expandMesh appends the CAs, cross signatures and leaves of t.Mesh to the
+// topology, so Generate treats them like entries of the file.
+func (t *Topology) expandMesh() error {
+	mesh := t.Mesh
+	if mesh == nil {
+		return nil
+	}
+	t.Mesh = nil
+	prefix := mesh.Prefix
+	if prefix == "" {
+		prefix = "ca"
+	}
+	layout := mesh.Layout
+	if layout == "" {
+		layout = "chain"
+	}
+	minCount := 2
+	if layout == "hub" {
+		minCount = 1
+	}
+	if mesh.Count < minCount {
+		return fmt.Errorf("mesh layout %s needs at least %d CAs, got %d", layout, minCount, mesh.Count)
+	}
+	leafProfiles := mesh.LeafProfiles
+	if leafProfiles == nil {
+		leafProfiles = []string{"tls-server", "tls-client"}
+	}
+	ipAddresses := mesh.IPAddresses
+	if ipAddresses == nil {
+		ipAddresses = []string{"127.0.0.1"}
+	}
+
+	names := make([]string, mesh.Count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s%d", prefix, i+1)
+		t.CAs = append(t.CAs, CASpec{Name: names[i]})
+	}
+	crossSign := func(issuer, subject string) {
+		t.CrossSigns = append(t.CrossSigns, CrossSignSpec{Name: issuer + "-" + subject, Issuer: issuer, Subject: subject})
+	}
+	switch layout {
+	case "chain":
+		for i := 0; i+1 < len(names); i++ {
+			crossSign(names[i], names[i+1])
+			crossSign(names[i+1], names[i])
+		}
+	case "mesh":
+		for _, issuer := range names {
+			for _, subject := range names {
+				if issuer != subject {
+					crossSign(issuer, subject)
+				}
+			}
+		}
+	case "hub":
+		bridge := mesh.Bridge
+		if bridge == "" {
+			bridge = "bridge"
+		}
+		t.CAs = append(t.CAs, CASpec{Name: bridge})
+		for _, name := range names {
+			crossSign(bridge, name)
+			crossSign(name, bridge)
+		}
+	default:
+		return fmt.Errorf("unknown mesh layout %q, want one of %v", layout, meshLayouts)
+	}
+	for _, name := range names {
+		for _, profile := range leafProfiles {
+			leafName := name + "-" + leafSuffix(profile)
+			t.Leaves = append(t.Leaves, LeafSpec{
+				Name:        leafName,
+				Issuer:      name,
+				Profile:     profile,
+				DNSNames:    []string{leafName, "localhost"},
+				IPAddresses: ipAddresses,
+			})
+		}
+	}
+	return nil
+}
+
+// runMesh generates a mesh of trust domains without a topology file.
+func runMesh(args []string) {
+	flags := flag.NewFlagSet("mesh", flag.ExitOnError)
+	count := flags.Int("n", 3, "number of CAs, the spokes for the hub layout")
+	layout := flags.String("layout", "chain", "cross signing layout, one of "+strings.Join(meshLayouts, ", "))
+	prefix := flags.String("prefix", "ca", "CA names are the prefix followed by 1..n")
+	bridge := flags.String("bridge", "bridge", "name of the bridge CA of the hub layout")
+	leafProfiles := flags.String("leaves", "tls-server,tls-client", "comma separated profiles of the leaves issued under each CA, empty for none")
+	outputDir := flags.String("out", "conf/mesh", "directory to write the certificates, keys and manifest to")
+	manifest := flags.String("manifest", "", "manifest path, defaults to manifest.yaml in the output directory")
+	keyAlgorithm := flags.String("key-algorithm", "ecdsa-p256", "key algorithm of every generated key")
+	trustDomain := flags.String("trust-domain", "", "give every leaf the SPIFFE ID spiffe://<trust-domain>/<leaf name>")
+	flags.Parse(args)
+
+	spec := &MeshSpec{Count: *count, Layout: *layout, Prefix: *prefix, Bridge: *bridge, LeafProfiles: []string{}}
+	if *leafProfiles != "" {
+		spec.LeafProfiles = strings.Split(*leafProfiles, ",")
+	}
+	for _, profile := range spec.LeafProfiles {
+		if _, err := pki.LookupProfile(profile); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *manifest == "" {
+		*manifest = filepath.Join(*outputDir, "manifest.yaml")
+	}
+	topology := &Topology{OutputDir: *outputDir, KeyAlgorithm: *keyAlgorithm, Mesh: spec, Manifest: *manifest, TrustDomain: *trustDomain}
+	if err := topology.Generate(); err != nil {
+		log.Fatalf("generate %s mesh of %d CAs failed, error %v", *layout, *count, err)
+	}
+	log.Printf("wrote %d files of a %s mesh of %d CAs, manifest %s", len(topology.manifest), *layout, *count, *manifest)
+}
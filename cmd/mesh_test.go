@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMesh(t *testing.T) {
+	for _, c := range []struct {
+		layout      string
+		count       int
+		crossCerts  int
+		leaf, root  string
+		chainLength int
+	}{
+		{layout: "chain", count: 3, crossCerts: 4, leaf: "ca1-server", root: "ca3", chainLength: 4},
+		{layout: "mesh", count: 3, crossCerts: 6, leaf: "ca1-server", root: "ca3", chainLength: 3},
+		{layout: "hub", count: 3, crossCerts: 6, leaf: "ca1-server", root: "ca3", chainLength: 4},
+	} {
+		dir := t.TempDir()
+		manifestPath := filepath.Join(dir, "manifest.yaml")
+		topology := &Topology{
+			OutputDir:    dir,
+			KeyAlgorithm: "ecdsa-p256",
+			Mesh:         &MeshSpec{Count: c.count, Layout: c.layout},
+			Manifest:     manifestPath,
+		}
+		if err := topology.Generate(); err != nil {
+			t.Fatalf("%s: %v", c.layout, err)
+		}
+		content, err := os.ReadFile(manifestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest []ManifestEntry
+		if err := yaml.Unmarshal(content, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		roles := map[string]int{}
+		for _, entry := range manifest {
+			if _, err := os.Stat(entry.File); err != nil {
+				t.Errorf("%s: manifest lists missing file %s", c.layout, entry.File)
+			}
+			roles[entry.Role]++
+		}
+		if roles["cross-cert"] != c.crossCerts || roles["leaf-cert"] != 2*c.count {
+			t.Errorf("%s: manifest roles are %v", c.layout, roles)
+		}
+
+		certs, err := readChainCerts([]string{filepath.Join(dir, "*-*.crt")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var intermediates []chainCert
+		for _, cert := range certs {
+			if cert.cert.IsCA {
+				intermediates = append(intermediates, cert)
+			}
+		}
+		leaf, err := readChainCerts([]string{filepath.Join(dir, c.leaf+".crt")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := readChainCerts([]string{filepath.Join(dir, c.root+".crt")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		shortest := 0
+		for _, result := range verifyChains(leaf[0], intermediates, []RootPool{{Name: c.root, Roots: root}}, VerifyOptions{}) {
+			if result.Valid && (shortest == 0 || len(result.Path) < shortest) {
+				shortest = len(result.Path)
+			}
+		}
+		if shortest != c.chainLength {
+			t.Errorf("%s: shortest chain from %s to %s has %d certificates, want %d", c.layout, c.leaf, c.root, shortest, c.chainLength)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"example.com/lx/beego/dev/pki"
+)
+
+// NameSpec is the subject, SANs and validity of one certificate, set by a
+// topology entry or by the flags of the generate command.
+//
+//	subject: /C=CN/ST=BeiJing/O=devCompany/OU=devTeam/CN=api
+//	sans: ["DNS:api.dev.local", "DNS:*.api.dev.local", "IP:10.0.0.5", "URI:spiffe://dev.local/api", "email:ops@dev.local"]
+//	notBefore: 2024-01-01
+//	notAfter: 2025-01-01T00:00:00Z  # or validity: 90d, 2160h
+type NameSpec struct {
+	Subject   string   `json:"subject" yaml:"subject"`
+	SANs      []string `json:"sans" yaml:"sans"`
+	NotBefore string   `json:"notBefore" yaml:"notBefore"`
+	NotAfter  string   `json:"notAfter" yaml:"notAfter"`
+	Validity  string   `json:"validity" yaml:"validity"`
+}
+
+// stringsFlag is a flag that may be repeated, each value may also be a
+// comma separated list.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, strings.Split(value, ",")...)
+	return nil
+}
+
+// register adds the flags of the spec, named with prefix, ca- for the CA
+// flags of the generate command.
+func (s *NameSpec) register(flags *flag.FlagSet, prefix, what string) {
+	flags.StringVar(&s.Subject, prefix+"subject", "", "subject of the "+what+", /C=CN/ST=BeiJing/O=devCompany/CN=name")
+	flags.Var((*stringsFlag)(&s.SANs), prefix+"san", "subject alternative name of the "+what+", DNS:, IP:, URI: or email: prefixed, repeatable")
+	flags.StringVar(&s.NotBefore, prefix+"not-before", "", "start of the validity of the "+what+", RFC 3339 or 2006-01-02, default now")
+	flags.StringVar(&s.NotAfter, prefix+"not-after", "", "end of the validity of the "+what+", RFC 3339 or 2006-01-02")
+	flags.StringVar(&s.Validity, prefix+"validity", "", "validity period of the "+what+" like 90d or 2160h, instead of -"+prefix+"not-after")
+}
+
+// apply sets the validity of opts and, for CAs, the subject and SANs.
+func (s NameSpec) apply(opts *pki.CertOptions, names bool) error {
+	var err error
+	if s.NotBefore != "" {
+		if opts.NotBefore, err = pki.ParseTime(s.NotBefore); err != nil {
+			return err
+		}
+	}
+	if s.NotAfter != "" {
+		if opts.NotAfter, err = pki.ParseTime(s.NotAfter); err != nil {
+			return err
+		}
+	}
+	if s.Validity != "" {
+		if s.NotAfter != "" {
+			return fmt.Errorf("set either notAfter or validity, not both")
+		}
+		if opts.Validity, err = pki.ParseDuration(s.Validity); err != nil {
+			return err
+		}
+	}
+	if !names {
+		return nil
+	}
+	if s.Subject != "" {
+		subject, err := pki.ParseSubject(s.Subject)
+		if err != nil {
+			return err
+		}
+		opts.Subject = &subject
+	}
+	if len(s.SANs) > 0 {
+		sans, err := pki.ParseSANs(s.SANs)
+		if err != nil {
+			return err
+		}
+		opts.SANs = &sans
+	}
+	return nil
+}
+
+// csrOptions requests the subject of the spec, commonName when it has
+// none, and its SANs after dnsNames and ipAddresses.
+func (s NameSpec) csrOptions(commonName string, dnsNames []string, ipAddresses []net.IP) (pki.CSROptions, error) {
+	subject, err := pki.ParseSubject(s.Subject)
+	if err != nil {
+		return pki.CSROptions{}, err
+	}
+	if subject.CommonName == "" {
+		subject.CommonName = commonName
+	}
+	sans, err := pki.ParseSANs(s.SANs)
+	if err != nil {
+		return pki.CSROptions{}, err
+	}
+	sans.DNSNames = append(append([]string{}, dnsNames...), sans.DNSNames...)
+	sans.IPAddresses = append(append([]net.IP{}, ipAddresses...), sans.IPAddresses...)
+	return pki.CSROptions{Subject: subject, SANs: sans}, nil
+}
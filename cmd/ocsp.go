@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"log"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+)
+
+// writeOCSPSigner generates the delegated signer of the CA at caCertPath
+// and stores it as <ca>.ocsp.crt and <ca>.ocsp.key, where the responder
+// of the bootstrap server looks for it.
+func writeOCSPSigner(caCertPath string, ca *issuer, keyAlgorithm, keyPassword string, opts pki.CertOptions) error {
+	privateKey, privateKeyBlock, err := pki.GeneratePrivateKey(rand.Reader, keyAlgorithm, keyPassword)
+	if err != nil {
+		return err
+	}
+	certBlock, err := pki.SignOCSPSignerCert(rand.Reader, privateKey.Public(), ca.certBlock, ca.privateKey, opts)
+	if err != nil {
+		return err
+	}
+	prefix := utils.CAPrefix(caCertPath)
+	if err := writeFile(prefix+".ocsp.key", privateKeyBlock); err != nil {
+		return err
+	}
+	if err := writeFile(prefix+".ocsp.crt", certBlock); err != nil {
+		return err
+	}
+	return pki.RecordIssuance(caCertPath, certBlock, prefix+".ocsp.crt")
+}
+
+func runOCSPSigner(args []string) {
+	flags := flag.NewFlagSet("ocsp-signer", flag.ExitOnError)
+	caFlag := caFlags(flags)
+	caCertPath := caFlag.cert
+	keyAlgorithm := flags.String("key-algorithm", pki.DefaultKeyAlgorithm, "key algorithm of the signer, one of rsa2048, rsa3072, rsa4096, ecdsa-p256, ecdsa-p384, ed25519")
+	validityDays := flags.Int("validity-days", 90, "days the signer certificate is valid")
+	flags.Parse(args)
+	_, ca := caFlag.load()
+	if err := writeOCSPSigner(*caCertPath, ca, *keyAlgorithm, caFlag.password(), pki.CertOptions{ValidityDays: *validityDays}); err != nil {
+		log.Fatalf("write ocsp signer failed, error %v", err)
+	}
+	log.Printf("wrote ocsp signer of %s to %s.ocsp.crt", *caCertPath, utils.CAPrefix(*caCertPath))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"example.com/lx/beego/dev/pki"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPResponder(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p256",
+		CAs:          []CASpec{{Name: "ca1", OCSPURL: "http://127.0.0.1:9090/ocsp"}},
+		Leaves:       []LeafSpec{{Name: "server1", Issuer: "ca1"}, {Name: "client1", Issuer: "ca1"}},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	caCertPath := filepath.Join(dir, "ca1.crt")
+	caCert := readCert(t, caCertPath)
+	server := readCert(t, filepath.Join(dir, "server1.crt"))
+	client := readCert(t, filepath.Join(dir, "client1.crt"))
+	if len(server.OCSPServer) != 1 || server.OCSPServer[0] != "http://127.0.0.1:9090/ocsp" {
+		t.Errorf("leaf ocsp servers are %v", server.OCSPServer)
+	}
+	if err := pki.RevokeCertificate(caCertPath, caCert, client.SerialNumber, client, "keyCompromise", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	authority, err := utils.LoadOCSPAuthority(caCertPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder := &utils.OCSPResponder{Authorities: []*utils.OCSPAuthority{authority}}
+	for _, c := range []struct {
+		name   string
+		status int
+	}{{"server1", ocsp.Good}, {"client1", ocsp.Revoked}} {
+		cert := readCert(t, filepath.Join(dir, c.name+".crt"))
+		request, err := ocsp.CreateRequest(cert, caCert, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := ocsp.ParseResponseForCert(responder.Respond(request), cert, caCert)
+		if err != nil {
+			t.Fatalf("parse response for %s failed, error %v", c.name, err)
+		}
+		if response.Status != c.status {
+			t.Errorf("status of %s is %d, want %d", c.name, response.Status, c.status)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+
+	"example.com/lx/beego/dev/pki"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// OutputOptions controls the extra protection and bundles written next to
+// the generated PEM files.
+type OutputOptions struct {
+	KeyPassword    string
+	PKCS12         bool
+	PKCS12Password string
+}
+
+// encodePKCS12 bundles the key, its certificate and the issuing chain the
+// way openssl pkcs12 -export does, using AES-256 and a SHA-256 MAC.
+func encodePKCS12(privateKey crypto.Signer, certBlockBytes []byte, chainBlockBytes [][]byte, password string) ([]byte, error) {
+	cert, err := pki.ParseCertificate(certBlockBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate failed, error %v", err)
+	}
+	chain := make([]*x509.Certificate, 0, len(chainBlockBytes))
+	for _, chainBlock := range chainBlockBytes {
+		caCert, err := pki.ParseCertificate(chainBlock)
+		if err != nil {
+			return nil, fmt.Errorf("parse chain certificate failed, error %v", err)
+		}
+		chain = append(chain, caCert)
+	}
+	return pkcs12.Modern.Encode(privateKey, cert, chain, password)
+}
+
+func writePKCS12(path string, privateKey crypto.Signer, certBlockBytes []byte, chainBlockBytes [][]byte, password string) error {
+	pfxData, err := encodePKCS12(privateKey, certBlockBytes, chainBlockBytes, password)
+	if err != nil {
+		return fmt.Errorf("encode pkcs12 %q failed, error %v", path, err)
+	}
+	return writeFile(path, pfxData)
+}
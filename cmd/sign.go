@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"example.com/lx/beego/dev/pki"
+)
+
+// readCSR reads a PEM or DER certificate request and returns it with its
+// PEM encoding, which SignServerCert expects.
+func readCSR(path string) (*x509.CertificateRequest, []byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read csr %q failed, error %v", path, err)
+	}
+	der := content
+	if block, _ := pem.Decode(content); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, nil, fmt.Errorf("%q holds a %s, not a certificate request", path, block.Type)
+		}
+		der = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse csr %q failed, error %v", path, err)
+	}
+	return csr, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+func runSign(args []string) {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	caFlag := caFlags(flags)
+	caCertPath := caFlag.cert
+	csrPath := flags.String("csr", "", "PEM or DER certificate request to sign")
+	out := flags.String("out", "", "where to write the certificate, defaults to the csr path with .crt")
+	policyFile := flags.String("policy", "", "YAML or JSON policy, defaults to <ca>.policy.yaml next to the CA certificate")
+	profile := flags.String("profile", pki.DefaultProfile, "issuance profile, one of "+strings.Join(pki.ProfileNames(), ", "))
+	validityDays := flags.Int("validity-days", 0, "days the certificate is valid, defaults to the profile validity capped by the policy")
+	crlURL := flags.String("crl-url", "", "CRL distribution point written into the certificate")
+	ocspURL := flags.String("ocsp-url", "", "AIA OCSP URL written into the certificate")
+	flags.Parse(args)
+	if *csrPath == "" {
+		log.Fatalf("-csr is required")
+	}
+	caCert, ca := caFlag.load()
+	if *policyFile == "" {
+		*policyFile = pki.PolicyPath(*caCertPath)
+	}
+	policy, err := pki.LoadPolicy(*policyFile)
+	if err != nil {
+		log.Fatalf("load policy failed, error %v", err)
+	}
+	csr, csrBlock, err := readCSR(*csrPath)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	days, err := policy.Check(csr, *profile, *validityDays)
+	if err != nil {
+		log.Fatalf("csr %q of %q rejected by policy %q: %v", *csrPath, csr.Subject.String(), *policyFile, err)
+	}
+	opts := pki.CertOptions{ValidityDays: days, Profile: *profile}
+	if *crlURL != "" {
+		opts.CRLDistributionPoints = []string{*crlURL}
+	}
+	if *ocspURL != "" {
+		opts.OCSPServers = []string{*ocspURL}
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(*csrPath, ".csr") + ".crt"
+	}
+	certBlock, err := pki.SignServerCert(rand.Reader, csrBlock, ca.certBlock, ca.privateKey, opts)
+	if err != nil {
+		log.Fatalf("sign %q failed, error %v", *csrPath, err)
+	}
+	if err := writeFile(*out, certBlock); err != nil {
+		log.Fatalf("write certificate failed, error %v", err)
+	}
+	if err := pki.RecordIssuance(*caCertPath, certBlock, *out); err != nil {
+		log.Fatalf("record issuance failed, error %v", err)
+	}
+	log.Printf("signed %s for %s with profile %s by %s, valid %d days, wrote %s", *csrPath, csr.Subject.String(), *profile, caCert.Subject.CommonName, days, *out)
+}
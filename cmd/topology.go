@@ -0,0 +1,449 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"example.com/lx/beego/dev/pki"
+	"example.com/lx/beego/dev/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Topology describes a whole trust layout: self-signed CAs, the cross
+// signatures between them, intermediates and the leaf certificates issued
+// under any of those. It is read from YAML or JSON by loadTopology.
+type Topology struct {
+	OutputDir     string             `json:"outputDir" yaml:"outputDir"`
+	KeyAlgorithm  string             `json:"keyAlgorithm" yaml:"keyAlgorithm"`
+	PKCS12        bool               `json:"pkcs12" yaml:"pkcs12"`
+	Output        OutputOptions      `json:"-" yaml:"-"`
+	CAs           []CASpec           `json:"cas" yaml:"cas"`
+	CrossSigns    []CrossSignSpec    `json:"crossSigns" yaml:"crossSigns"`
+	Intermediates []IntermediateSpec `json:"intermediates" yaml:"intermediates"`
+	Leaves        []LeafSpec         `json:"leaves" yaml:"leaves"`
+	// Mesh adds generated CAs, cross signatures and leaves to the above.
+	Mesh *MeshSpec `json:"mesh" yaml:"mesh"`
+	// Manifest is where to write the list of generated files and their
+	// roles, YAML or JSON by extension; nothing is written when empty.
+	Manifest string          `json:"manifest" yaml:"manifest"`
+	manifest []ManifestEntry `json:"-" yaml:"-"`
+	// TrustDomain gives every leaf the SPIFFE ID spiffe://<domain>/<name>
+	// as its URI SAN, unless the leaf names its own spiffeID.
+	TrustDomain string `json:"trustDomain" yaml:"trustDomain"`
+}
+
+// ManifestEntry describes one generated file. Issuer is the topology name
+// of the signing CA, empty for self-signed CAs and key files.
+type ManifestEntry struct {
+	File   string `json:"file" yaml:"file"`
+	Role   string `json:"role" yaml:"role"`
+	Name   string `json:"name" yaml:"name"`
+	Issuer string `json:"issuer,omitempty" yaml:"issuer,omitempty"`
+}
+
+type CASpec struct {
+	Name                 string `json:"name" yaml:"name"`
+	CommonName           string `json:"commonName" yaml:"commonName"`
+	KeyAlgorithm         string `json:"keyAlgorithm" yaml:"keyAlgorithm"`
+	ValidityDays         int    `json:"validityDays" yaml:"validityDays"`
+	CRLDistributionPoint string `json:"crlDistributionPoint" yaml:"crlDistributionPoint"`
+	OCSPURL              string `json:"ocspURL" yaml:"ocspURL"`
+	Key                  string `json:"key" yaml:"key"`
+	MaxPathLen           *int   `json:"maxPathLen" yaml:"maxPathLen"`
+	Cert                 string `json:"cert" yaml:"cert"`
+	NameSpec             `yaml:",inline"`
+}
+
+// CrossSignSpec makes Issuer sign the certificate of Subject. When Name is
+// set the result can be used as the issuer of leaves, signing with the key
+// of Subject.
+type CrossSignSpec struct {
+	Name         string `json:"name" yaml:"name"`
+	Issuer       string `json:"issuer" yaml:"issuer"`
+	Subject      string `json:"subject" yaml:"subject"`
+	ValidityDays int    `json:"validityDays" yaml:"validityDays"`
+	MaxPathLen   *int   `json:"maxPathLen" yaml:"maxPathLen"`
+	Cert         string `json:"cert" yaml:"cert"`
+}
+
+type IntermediateSpec struct {
+	Name                 string `json:"name" yaml:"name"`
+	Issuer               string `json:"issuer" yaml:"issuer"`
+	CommonName           string `json:"commonName" yaml:"commonName"`
+	KeyAlgorithm         string `json:"keyAlgorithm" yaml:"keyAlgorithm"`
+	ValidityDays         int    `json:"validityDays" yaml:"validityDays"`
+	CRLDistributionPoint string `json:"crlDistributionPoint" yaml:"crlDistributionPoint"`
+	OCSPURL              string `json:"ocspURL" yaml:"ocspURL"`
+	Key                  string `json:"key" yaml:"key"`
+	MaxPathLen           *int   `json:"maxPathLen" yaml:"maxPathLen"`
+	Cert                 string `json:"cert" yaml:"cert"`
+	NameSpec             `yaml:",inline"`
+}
+
+type LeafSpec struct {
+	Name         string   `json:"name" yaml:"name"`
+	Issuer       string   `json:"issuer" yaml:"issuer"`
+	CommonName   string   `json:"commonName" yaml:"commonName"`
+	DNSNames     []string `json:"dnsNames" yaml:"dnsNames"`
+	IPAddresses  []string `json:"ipAddresses" yaml:"ipAddresses"`
+	ValidityDays int      `json:"validityDays" yaml:"validityDays"`
+	KeyAlgorithm string   `json:"keyAlgorithm" yaml:"keyAlgorithm"`
+	Profile      string   `json:"profile" yaml:"profile"`
+	Key          string   `json:"key" yaml:"key"`
+	Cert         string   `json:"cert" yaml:"cert"`
+	PKCS12       string   `json:"pkcs12" yaml:"pkcs12"`
+	// SPIFFEID is a path under the trust domain, /ns/prod/sa/api, or a
+	// full spiffe:// ID.
+	SPIFFEID string `json:"spiffeID" yaml:"spiffeID"`
+	NameSpec `yaml:",inline"`
+}
+
+type issuer struct {
+	privateKey crypto.Signer
+	certBlock  []byte
+	chain      [][]byte
+	crlURL     string
+	ocspURL    string
+	// certPath locates the CA database, <ca>.index.txt, that records what
+	// this issuer signs.
+	certPath string
+}
+
+// issuedChain is the chain to ship with a certificate signed by i.
+func (i *issuer) issuedChain() [][]byte {
+	return append([][]byte{i.certBlock}, i.chain...)
+}
+
+func loadTopology(path string) (*Topology, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read topology file %q failed, error %v", path, err)
+	}
+	topology := &Topology{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(content, topology)
+	} else {
+		err = yaml.Unmarshal(content, topology)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse topology file %q failed, error %v", path, err)
+	}
+	if topology.OutputDir == "" {
+		topology.OutputDir = "conf/certs"
+	}
+	return topology, nil
+}
+
+// spiffeID is the SPIFFE ID of leaf, nil when neither the leaf nor the
+// topology asks for one.
+func (t *Topology) spiffeID(leaf LeafSpec) (*url.URL, error) {
+	switch {
+	case strings.HasPrefix(leaf.SPIFFEID, "spiffe://"):
+		id, err := pki.ParseSPIFFEID(leaf.SPIFFEID)
+		if err == nil && t.TrustDomain != "" && !pki.InTrustDomain(id, t.TrustDomain) {
+			err = fmt.Errorf("spiffe id %s is outside the trust domain %s", id, t.TrustDomain)
+		}
+		return id, err
+	case t.TrustDomain == "":
+		if leaf.SPIFFEID != "" {
+			return nil, fmt.Errorf("spiffe id path %q needs a trustDomain", leaf.SPIFFEID)
+		}
+		return nil, nil
+	case leaf.SPIFFEID != "":
+		return pki.SPIFFEID(t.TrustDomain, leaf.SPIFFEID)
+	default:
+		return pki.SPIFFEID(t.TrustDomain, leaf.Name)
+	}
+}
+
+func (t *Topology) path(configured, name, ext string) string {
+	if configured != "" {
+		return configured
+	}
+	return filepath.Join(t.OutputDir, name+ext)
+}
+
+func (t *Topology) keyAlgorithm(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	return t.KeyAlgorithm
+}
+
+func (t *Topology) write(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create directory for %q failed, error %v", path, err)
+	}
+	return writeFile(path, content)
+}
+
+func (t *Topology) record(file, role, name, issuer string) {
+	t.manifest = append(t.manifest, ManifestEntry{File: file, Role: role, Name: name, Issuer: issuer})
+}
+
+func (t *Topology) writeManifest() error {
+	var content []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(t.Manifest), ".json") {
+		content, err = json.MarshalIndent(t.manifest, "", "  ")
+	} else {
+		content, err = yaml.Marshal(t.manifest)
+	}
+	if err != nil {
+		return err
+	}
+	return t.write(t.Manifest, content)
+}
+
+func (t *Topology) Generate() error {
+	if err := t.expandMesh(); err != nil {
+		return err
+	}
+	issuers := make(map[string]*issuer)
+	register := func(name string, i *issuer) error {
+		if name == "" {
+			return fmt.Errorf("topology entry without a name")
+		}
+		if _, ok := issuers[name]; ok {
+			return fmt.Errorf("duplicate topology name %q", name)
+		}
+		issuers[name] = i
+		return nil
+	}
+	lookup := func(name string) (*issuer, error) {
+		i, ok := issuers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown issuer %q", name)
+		}
+		return i, nil
+	}
+
+	for _, ca := range t.CAs {
+		log.Printf("generate ca %s", ca.Name)
+		privateKey, privateKeyBlock, err := pki.GeneratePrivateKey(rand.Reader, t.keyAlgorithm(ca.KeyAlgorithm), t.Output.KeyPassword)
+		if err != nil {
+			return fmt.Errorf("generate private key of ca %q failed, error %v", ca.Name, err)
+		}
+		commonName := ca.CommonName
+		if commonName == "" {
+			commonName = ca.Name
+		}
+		opts := pki.CertOptions{ValidityDays: ca.ValidityDays, MaxPathLen: ca.MaxPathLen}
+		if err := ca.NameSpec.apply(&opts, true); err != nil {
+			return fmt.Errorf("ca %q: %v", ca.Name, err)
+		}
+		certBlock, err := pki.SignCACert(rand.Reader, privateKey, commonName, opts)
+		if err != nil {
+			return fmt.Errorf("sign ca %q failed, error %v", ca.Name, err)
+		}
+		certPath := t.path(ca.Cert, ca.Name, ".crt")
+		caIssuer := &issuer{privateKey: privateKey, certBlock: certBlock, crlURL: ca.CRLDistributionPoint, ocspURL: ca.OCSPURL, certPath: certPath}
+		if err := register(ca.Name, caIssuer); err != nil {
+			return err
+		}
+		if err := t.write(t.path(ca.Key, ca.Name, ".key"), privateKeyBlock); err != nil {
+			return err
+		}
+		if err := t.write(certPath, certBlock); err != nil {
+			return err
+		}
+		if err := pki.StartIndex(certPath); err != nil {
+			return err
+		}
+		if err := pki.RecordIssuance(certPath, certBlock, certPath); err != nil {
+			return err
+		}
+		t.record(t.path(ca.Key, ca.Name, ".key"), "ca-key", ca.Name, "")
+		t.record(certPath, "ca-cert", ca.Name, "")
+		t.record(utils.IndexPath(certPath), "ca-database", ca.Name, "")
+		if ca.OCSPURL != "" {
+			if err := writeOCSPSigner(certPath, caIssuer, t.keyAlgorithm(ca.KeyAlgorithm), t.Output.KeyPassword, pki.CertOptions{ValidityDays: ca.ValidityDays}); err != nil {
+				return fmt.Errorf("generate ocsp signer of ca %q failed, error %v", ca.Name, err)
+			}
+			t.recordOCSPSigner(certPath, ca.Name)
+		}
+	}
+
+	for _, cross := range t.CrossSigns {
+		log.Printf("generate cross cert %s -> %s", cross.Issuer, cross.Subject)
+		signer, err := lookup(cross.Issuer)
+		if err != nil {
+			return err
+		}
+		subject, err := lookup(cross.Subject)
+		if err != nil {
+			return err
+		}
+		certBlock, err := pki.SignCrossCert(rand.Reader, signer.privateKey, signer.certBlock, subject.certBlock, pki.CertOptions{ValidityDays: cross.ValidityDays, MaxPathLen: cross.MaxPathLen})
+		if err != nil {
+			return fmt.Errorf("cross sign %q by %q failed, error %v", cross.Subject, cross.Issuer, err)
+		}
+		name := cross.Name
+		if name == "" {
+			name = cross.Issuer + "-" + cross.Subject
+		} else if err := register(name, &issuer{privateKey: subject.privateKey, certBlock: certBlock, chain: signer.issuedChain(), crlURL: subject.crlURL, ocspURL: subject.ocspURL, certPath: subject.certPath}); err != nil {
+			return err
+		}
+		if err := t.write(t.path(cross.Cert, name, ".crt"), certBlock); err != nil {
+			return err
+		}
+		if err := pki.RecordIssuance(signer.certPath, certBlock, t.path(cross.Cert, name, ".crt")); err != nil {
+			return err
+		}
+		t.record(t.path(cross.Cert, name, ".crt"), "cross-cert", name, cross.Issuer)
+	}
+
+	for _, intermediate := range t.Intermediates {
+		log.Printf("generate intermediate %s under %s", intermediate.Name, intermediate.Issuer)
+		signer, err := lookup(intermediate.Issuer)
+		if err != nil {
+			return err
+		}
+		privateKey, privateKeyBlock, err := pki.GeneratePrivateKey(rand.Reader, t.keyAlgorithm(intermediate.KeyAlgorithm), t.Output.KeyPassword)
+		if err != nil {
+			return fmt.Errorf("generate private key of intermediate %q failed, error %v", intermediate.Name, err)
+		}
+		commonName := intermediate.CommonName
+		if commonName == "" {
+			commonName = intermediate.Name
+		}
+		opts := pki.CertOptions{ValidityDays: intermediate.ValidityDays, MaxPathLen: intermediate.MaxPathLen}
+		if err := intermediate.NameSpec.apply(&opts, true); err != nil {
+			return fmt.Errorf("intermediate %q: %v", intermediate.Name, err)
+		}
+		if signer.crlURL != "" {
+			opts.CRLDistributionPoints = []string{signer.crlURL}
+		}
+		if signer.ocspURL != "" {
+			opts.OCSPServers = []string{signer.ocspURL}
+		}
+		certBlock, err := pki.SignSubCACert(rand.Reader, privateKey.Public(), commonName, signer.certBlock, signer.privateKey, opts)
+		if err != nil {
+			return fmt.Errorf("sign intermediate %q failed, error %v", intermediate.Name, err)
+		}
+		certPath := t.path(intermediate.Cert, intermediate.Name, ".crt")
+		intermediateIssuer := &issuer{privateKey: privateKey, certBlock: certBlock, chain: signer.issuedChain(), crlURL: intermediate.CRLDistributionPoint, ocspURL: intermediate.OCSPURL, certPath: certPath}
+		if err := register(intermediate.Name, intermediateIssuer); err != nil {
+			return err
+		}
+		if err := t.write(t.path(intermediate.Key, intermediate.Name, ".key"), privateKeyBlock); err != nil {
+			return err
+		}
+		if err := t.write(certPath, certBlock); err != nil {
+			return err
+		}
+		if err := pki.RecordIssuance(signer.certPath, certBlock, certPath); err != nil {
+			return err
+		}
+		if err := pki.StartIndex(certPath); err != nil {
+			return err
+		}
+		t.record(t.path(intermediate.Key, intermediate.Name, ".key"), "intermediate-key", intermediate.Name, "")
+		t.record(certPath, "intermediate-cert", intermediate.Name, intermediate.Issuer)
+		t.record(utils.IndexPath(certPath), "ca-database", intermediate.Name, "")
+		if intermediate.OCSPURL != "" {
+			if err := writeOCSPSigner(certPath, intermediateIssuer, t.keyAlgorithm(intermediate.KeyAlgorithm), t.Output.KeyPassword, pki.CertOptions{ValidityDays: intermediate.ValidityDays}); err != nil {
+				return fmt.Errorf("generate ocsp signer of intermediate %q failed, error %v", intermediate.Name, err)
+			}
+			t.recordOCSPSigner(certPath, intermediate.Name)
+		}
+	}
+
+	for _, leaf := range t.Leaves {
+		log.Printf("generate leaf %s under %s", leaf.Name, leaf.Issuer)
+		signer, err := lookup(leaf.Issuer)
+		if err != nil {
+			return err
+		}
+		if _, err := pki.LookupProfile(leaf.Profile); err != nil {
+			return fmt.Errorf("leaf %q: %v", leaf.Name, err)
+		}
+		commonName := leaf.CommonName
+		if commonName == "" {
+			commonName = leaf.Name
+		}
+		dnsNames := leaf.DNSNames
+		if len(dnsNames) == 0 && len(leaf.SANs) == 0 {
+			dnsNames = []string{commonName}
+		}
+		ipAddresses := make([]net.IP, 0, len(leaf.IPAddresses))
+		for _, ipString := range leaf.IPAddresses {
+			ip := net.ParseIP(ipString)
+			if ip == nil {
+				return fmt.Errorf("leaf %q has invalid ip address %q", leaf.Name, ipString)
+			}
+			ipAddresses = append(ipAddresses, ip)
+		}
+		privateKey, privateKeyBlock, err := pki.GeneratePrivateKey(rand.Reader, t.keyAlgorithm(leaf.KeyAlgorithm), t.Output.KeyPassword)
+		if err != nil {
+			return fmt.Errorf("generate private key of leaf %q failed, error %v", leaf.Name, err)
+		}
+		csrOpts, err := leaf.NameSpec.csrOptions(commonName, dnsNames, ipAddresses)
+		if err != nil {
+			return fmt.Errorf("leaf %q: %v", leaf.Name, err)
+		}
+		spiffeID, err := t.spiffeID(leaf)
+		if err != nil {
+			return fmt.Errorf("leaf %q: %v", leaf.Name, err)
+		}
+		if spiffeID != nil {
+			if len(csrOpts.URIs) > 0 {
+				return fmt.Errorf("leaf %q has the spiffe id %s, it cannot carry other URI SANs", leaf.Name, spiffeID)
+			}
+			csrOpts.URIs = []*url.URL{spiffeID}
+		}
+		csrBlock, err := pki.CreateCSR(rand.Reader, privateKey, csrOpts)
+		if err != nil {
+			return fmt.Errorf("generate csr of leaf %q failed, error %v", leaf.Name, err)
+		}
+		opts := pki.CertOptions{ValidityDays: leaf.ValidityDays, Profile: leaf.Profile}
+		if err := leaf.NameSpec.apply(&opts, false); err != nil {
+			return fmt.Errorf("leaf %q: %v", leaf.Name, err)
+		}
+		if signer.crlURL != "" {
+			opts.CRLDistributionPoints = []string{signer.crlURL}
+		}
+		if signer.ocspURL != "" {
+			opts.OCSPServers = []string{signer.ocspURL}
+		}
+		certBlock, err := pki.SignServerCert(rand.Reader, csrBlock, signer.certBlock, signer.privateKey, opts)
+		if err != nil {
+			return fmt.Errorf("sign leaf %q failed, error %v", leaf.Name, err)
+		}
+		if err := t.write(t.path(leaf.Key, leaf.Name, ".key"), privateKeyBlock); err != nil {
+			return err
+		}
+		if err := t.write(t.path(leaf.Cert, leaf.Name, ".crt"), certBlock); err != nil {
+			return err
+		}
+		if err := pki.RecordIssuance(signer.certPath, certBlock, t.path(leaf.Cert, leaf.Name, ".crt")); err != nil {
+			return err
+		}
+		t.record(t.path(leaf.Key, leaf.Name, ".key"), "leaf-key", leaf.Name, "")
+		t.record(t.path(leaf.Cert, leaf.Name, ".crt"), "leaf-cert", leaf.Name, leaf.Issuer)
+		if t.PKCS12 || t.Output.PKCS12 || leaf.PKCS12 != "" {
+			if err := writePKCS12(t.path(leaf.PKCS12, leaf.Name, ".p12"), privateKey, certBlock, signer.issuedChain(), t.Output.PKCS12Password); err != nil {
+				return err
+			}
+			t.record(t.path(leaf.PKCS12, leaf.Name, ".p12"), "leaf-pkcs12", leaf.Name, leaf.Issuer)
+		}
+	}
+	if t.Manifest != "" {
+		return t.writeManifest()
+	}
+	return nil
+}
+
+func (t *Topology) recordOCSPSigner(caCertPath, name string) {
+	prefix := utils.CAPrefix(caCertPath)
+	t.record(prefix+".ocsp.key", "ocsp-signer-key", name, "")
+	t.record(prefix+".ocsp.crt", "ocsp-signer-cert", name, name)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func readCert(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %q failed, error %v", path, err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		t.Fatalf("%q is not PEM", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse %q failed, error %v", path, err)
+	}
+	return cert
+}
+
+func TestTopologyGenerate(t *testing.T) {
+	dir := t.TempDir()
+	topologyFile := filepath.Join(dir, "topology.json")
+	content := `{
+		"outputDir": "` + dir + `",
+		"cas": [{"name": "root", "commonName": "TestRoot", "validityDays": 30}],
+		"leaves": [{"name": "web", "issuer": "root", "dnsNames": ["web.local"], "ipAddresses": ["10.0.0.1"], "profile": "server"}]
+	}`
+	if err := os.WriteFile(topologyFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	topology, err := loadTopology(topologyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	root := readCert(t, filepath.Join(dir, "root.crt"))
+	leaf := readCert(t, filepath.Join(dir, "web.crt"))
+	if days := root.NotAfter.Sub(root.NotBefore).Hours() / 24; days != 30 {
+		t.Errorf("root validity is %v days, want 30", days)
+	}
+	if len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("leaf ext key usage is %v, want server auth only", leaf.ExtKeyUsage)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "web.local"}); err != nil {
+		t.Errorf("verify leaf failed, error %v", err)
+	}
+}
+
+func TestTopologyUnknownIssuer(t *testing.T) {
+	topology := &Topology{
+		OutputDir: t.TempDir(),
+		Leaves:    []LeafSpec{{Name: "orphan", Issuer: "missing"}},
+	}
+	if err := topology.Generate(); err == nil {
+		t.Fatal("expected an error for an unknown issuer")
+	}
+}
+
+func TestTopologyKeyAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p384",
+		CAs:          []CASpec{{Name: "root"}},
+		Leaves: []LeafSpec{
+			{Name: "ed", Issuer: "root", KeyAlgorithm: "ed25519"},
+			{Name: "ec", Issuer: "root", KeyAlgorithm: "ecdsa-p256"},
+			{Name: "rsa", Issuer: "root", KeyAlgorithm: "rsa2048"},
+		},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	root := readCert(t, filepath.Join(dir, "root.crt"))
+	if root.SignatureAlgorithm != x509.ECDSAWithSHA384 {
+		t.Errorf("root signature algorithm is %v, want %v", root.SignatureAlgorithm, x509.ECDSAWithSHA384)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	for _, name := range []string{"ed", "ec", "rsa"} {
+		leaf := readCert(t, filepath.Join(dir, name+".crt"))
+		if leaf.SignatureAlgorithm != x509.ECDSAWithSHA384 {
+			t.Errorf("%s signature algorithm is %v, want the issuer's %v", name, leaf.SignatureAlgorithm, x509.ECDSAWithSHA384)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+			t.Errorf("verify %s failed, error %v", name, err)
+		}
+	}
+	topology.Leaves = []LeafSpec{{Name: "bad", Issuer: "root", KeyAlgorithm: "dsa"}}
+	if err := topology.Generate(); err == nil || !strings.Contains(err.Error(), "unsupported key algorithm") {
+		t.Errorf("expected an unsupported key algorithm error, got %v", err)
+	}
+}
+
+func TestTopologyPKCS12(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p256",
+		CAs:          []CASpec{{Name: "root"}},
+		Leaves:       []LeafSpec{{Name: "web", Issuer: "root", PKCS12: filepath.Join(dir, "bundle.p12")}},
+		Output:       OutputOptions{PKCS12Password: "123456"},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := os.ReadFile(filepath.Join(dir, "bundle.p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cert, chain, err := pkcs12.DecodeChain(pfxData, "123456")
+	if err != nil {
+		t.Fatalf("decode pkcs12 failed, error %v", err)
+	}
+	if cert.Subject.CommonName != "web" || len(chain) != 1 || chain[0].Subject.CommonName != "root" {
+		t.Errorf("unexpected pkcs12 content %q with chain %v", cert.Subject.CommonName, chain)
+	}
+}
+
+func TestTopologyHierarchy(t *testing.T) {
+	dir := t.TempDir()
+	one := 1
+	topology := &Topology{
+		OutputDir:     dir,
+		KeyAlgorithm:  "ecdsa-p256",
+		CAs:           []CASpec{{Name: "root", MaxPathLen: &one}, {Name: "other"}},
+		CrossSigns:    []CrossSignSpec{{Name: "root-by-other", Issuer: "other", Subject: "root"}},
+		Intermediates: []IntermediateSpec{{Name: "sub", Issuer: "root"}, {Name: "subsub", Issuer: "sub"}},
+		Leaves: []LeafSpec{
+			{Name: "leaf", Issuer: "sub"},
+			{Name: "deep", Issuer: "subsub"},
+			{Name: "crossed", Issuer: "root-by-other"},
+		},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	cert := func(name string) *x509.Certificate {
+		return readCert(t, filepath.Join(dir, name+".crt"))
+	}
+	pool := func(names ...string) *x509.CertPool {
+		p := x509.NewCertPool()
+		for _, name := range names {
+			p.AddCert(cert(name))
+		}
+		return p
+	}
+	root, sub, leaf, cross := cert("root"), cert("sub"), cert("leaf"), cert("root-by-other")
+	if root.MaxPathLen != 1 || !sub.IsCA || sub.MaxPathLen != 0 || !sub.MaxPathLenZero {
+		t.Errorf("root path len %d, sub ca %v path len %d", root.MaxPathLen, sub.IsCA, sub.MaxPathLen)
+	}
+	if !bytes.Equal(sub.AuthorityKeyId, root.SubjectKeyId) || !bytes.Equal(leaf.AuthorityKeyId, sub.SubjectKeyId) || len(leaf.SubjectKeyId) == 0 {
+		t.Error("key identifiers do not link leaf, sub and root")
+	}
+	if leaf.IsCA || leaf.KeyUsage&x509.KeyUsageCertSign != 0 {
+		t.Error("leaf must not be a CA")
+	}
+	if !cross.IsCA || !bytes.Equal(cross.SubjectKeyId, root.SubjectKeyId) {
+		t.Error("cross certificate must be a CA with the key id of root")
+	}
+
+	verify := func(name string, roots *x509.CertPool, intermediates ...string) error {
+		_, err := cert(name).Verify(x509.VerifyOptions{Roots: roots, Intermediates: pool(intermediates...), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		return err
+	}
+	if err := verify("leaf", pool("root"), "sub"); err != nil {
+		t.Errorf("verify leaf under sub failed, error %v", err)
+	}
+	if err := verify("deep", pool("root"), "sub", "subsub"); err == nil {
+		t.Error("expected sub with path length 0 to be unable to issue subsub")
+	}
+	if err := verify("crossed", pool("other"), "root-by-other"); err != nil {
+		t.Errorf("verify leaf through the cross certificate failed, error %v", err)
+	}
+	if err := verify("leaf", pool("other"), "root-by-other", "sub"); err != nil {
+		t.Errorf("verify leaf under sub through the cross certificate failed, error %v", err)
+	}
+}
+
+func TestTopologyNames(t *testing.T) {
+	dir := t.TempDir()
+	topologyFile := filepath.Join(dir, "topology.yaml")
+	content := `outputDir: ` + dir + `
+keyAlgorithm: ecdsa-p256
+cas:
+  - name: root
+    subject: /C=DE/L=Berlin/O=Example GmbH/OU=Platform/CN=Example Root
+    notBefore: 2024-01-01
+    notAfter: 2034-01-01
+leaves:
+  - name: api
+    issuer: root
+    subject: /O=Example GmbH/CN=api
+    sans: ["DNS:*.api.example.test", "IP:10.0.0.5", "URI:spiffe://example.test/api", "email:ops@example.test"]
+    validity: 90d
+`
+	if err := os.WriteFile(topologyFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	topology, err := loadTopology(topologyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	root := readCert(t, filepath.Join(dir, "root.crt"))
+	if got := root.Subject.String(); got != "CN=Example Root,OU=Platform,O=Example GmbH,L=Berlin,C=DE" {
+		t.Errorf("root subject is %s", got)
+	}
+	if root.NotBefore.Format("2006-01-02") != "2024-01-01" || root.NotAfter.Format("2006-01-02") != "2034-01-01" {
+		t.Errorf("root is valid from %v to %v", root.NotBefore, root.NotAfter)
+	}
+	leaf := readCert(t, filepath.Join(dir, "api.crt"))
+	if leaf.Subject.CommonName != "api" || len(leaf.Subject.Organization) != 1 {
+		t.Errorf("leaf subject is %s", leaf.Subject)
+	}
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "*.api.example.test" || len(leaf.IPAddresses) != 1 ||
+		len(leaf.URIs) != 1 || leaf.URIs[0].String() != "spiffe://example.test/api" || len(leaf.EmailAddresses) != 1 {
+		t.Errorf("leaf SANs are %v %v %v %v", leaf.DNSNames, leaf.IPAddresses, leaf.URIs, leaf.EmailAddresses)
+	}
+	if days := leaf.NotAfter.Sub(leaf.NotBefore).Hours() / 24; days != 90 {
+		t.Errorf("leaf validity is %v days, want 90", days)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "v1.api.example.test"}); err != nil {
+		t.Errorf("verify wildcard leaf failed, error %v", err)
+	}
+
+	expired := &Topology{OutputDir: dir, CAs: []CASpec{{Name: "bad", NameSpec: NameSpec{NotBefore: "2030-01-01", NotAfter: "2029-01-01"}}}}
+	if err := expired.Generate(); err == nil {
+		t.Error("expected an error for a not after before the not before")
+	}
+}
+
+func TestTopologySPIFFE(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:    dir,
+		KeyAlgorithm: "ecdsa-p256",
+		TrustDomain:  "dev.local",
+		CAs:          []CASpec{{Name: "root"}},
+		Leaves: []LeafSpec{
+			{Name: "api", Issuer: "root"},
+			{Name: "worker", Issuer: "root", SPIFFEID: "/ns/prod/sa/worker"},
+		},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"api": "spiffe://dev.local/api", "worker": "spiffe://dev.local/ns/prod/sa/worker"} {
+		cert := readCert(t, filepath.Join(dir, name+".crt"))
+		if len(cert.URIs) != 1 || cert.URIs[0].String() != want {
+			t.Errorf("%s has URI SANs %v, want %s", name, cert.URIs, want)
+		}
+	}
+	for _, leaf := range []LeafSpec{
+		{Name: "foreign", Issuer: "root", SPIFFEID: "spiffe://other.local/api"},
+		{Name: "two", Issuer: "root", NameSpec: NameSpec{SANs: []string{"URI:https://dev.local/api"}}},
+	} {
+		topology := &Topology{OutputDir: dir, KeyAlgorithm: "ecdsa-p256", TrustDomain: "dev.local", CAs: []CASpec{{Name: "root"}}, Leaves: []LeafSpec{leaf}}
+		if err := topology.Generate(); err == nil {
+			t.Errorf("expected an error for leaf %s", leaf.Name)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxChainLength bounds the candidate paths enumerated by verifyChains.
+const maxChainLength = 8
+
+// chainCert is a certificate with the file it came from, for reporting.
+type chainCert struct {
+	file string
+	cert *x509.Certificate
+}
+
+func (c chainCert) String() string {
+	return fmt.Sprintf("%s (%s)", c.cert.Subject.CommonName, filepath.Base(c.file))
+}
+
+// RootPool is a named set of trust anchors, e.g. the roots of one Kafka
+// cluster's truststore.
+type RootPool struct {
+	Name  string
+	Roots []chainCert
+}
+
+// ChainResult is one candidate path from the leaf to a root of Pool. Valid
+// paths verify with x509; Reasons explain why the others do not.
+type ChainResult struct {
+	Pool    string   `json:"pool"`
+	Path    []string `json:"path"`
+	Valid   bool     `json:"valid"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// VerifyOptions are the x509 verification settings applied to every path.
+type VerifyOptions struct {
+	DNSName     string
+	KeyUsages   []x509.ExtKeyUsage
+	CurrentTime time.Time
+}
+
+// readChainCerts reads every certificate of the files matched by patterns.
+func readChainCerts(patterns []string) ([]chainCert, error) {
+	var certs []chainCert
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no certificate file matches %q", pattern)
+		}
+		for _, path := range paths {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			for {
+				var block *pem.Block
+				block, content = pem.Decode(content)
+				if block == nil {
+					break
+				}
+				if block.Type != "CERTIFICATE" {
+					continue
+				}
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, fmt.Errorf("parse certificate in %q failed, error %v", path, err)
+				}
+				certs = append(certs, chainCert{file: path, cert: cert})
+			}
+		}
+	}
+	return certs, nil
+}
+
+// candidatePath is a path from the leaf that ends at a root of the pool
+// when anchored. Otherwise it dead ends, at a certificate whose issuers are
+// missing or, when loop is set, already in the path.
+type candidatePath struct {
+	certs    []chainCert
+	anchored bool
+	loop     *chainCert
+}
+
+// candidatePaths enumerates every path from leaf through certs whose
+// subject names the issuer of the previous one. Like x509, a path never
+// uses the same subject and key twice.
+func candidatePaths(leaf chainCert, intermediates []chainCert, roots []chainCert) []candidatePath {
+	isRoot := func(c chainCert) bool {
+		for _, root := range roots {
+			if root.cert.Equal(c.cert) {
+				return true
+			}
+		}
+		return false
+	}
+	candidates := append(append([]chainCert{}, intermediates...), roots...)
+	var paths []candidatePath
+	var walk func(path []chainCert)
+	walk = func(path []chainCert) {
+		last := path[len(path)-1]
+		if len(path) > 1 && isRoot(last) {
+			paths = append(paths, candidatePath{certs: path, anchored: true})
+			return
+		}
+		extended := false
+		var loop *chainCert
+		for _, candidate := range candidates {
+			if !bytes.Equal(candidate.cert.RawSubject, last.cert.RawIssuer) || len(path) >= maxChainLength {
+				continue
+			}
+			if looped := inPath(path, candidate); looped != nil {
+				loop = looped
+				continue
+			}
+			extended = true
+			walk(append(append([]chainCert{}, path...), candidate))
+		}
+		if !extended {
+			paths = append(paths, candidatePath{certs: path, loop: loop})
+		}
+	}
+	walk([]chainCert{leaf})
+	return paths
+}
+
+// inPath returns the certificate of path with the subject and key of c.
+func inPath(path []chainCert, c chainCert) *chainCert {
+	for i, p := range path {
+		if sameSubjectKey(p.cert, c.cert) {
+			return &path[i]
+		}
+	}
+	return nil
+}
+
+// sameSubjectKey reports whether a and b certify the same CA, as a root
+// and its cross certificates do.
+func sameSubjectKey(a, b *x509.Certificate) bool {
+	return bytes.Equal(a.RawSubject, b.RawSubject) && bytes.Equal(a.RawSubjectPublicKeyInfo, b.RawSubjectPublicKeyInfo)
+}
+
+// linkProblems lists what is wrong with issuer signing child at position
+// depth of path, 0 being the leaf.
+func linkProblems(path []chainCert, depth int, opts VerifyOptions) []string {
+	child, issuer := path[depth], path[depth+1]
+	var problems []string
+	if len(child.cert.AuthorityKeyId) > 0 && len(issuer.cert.SubjectKeyId) > 0 && !bytes.Equal(child.cert.AuthorityKeyId, issuer.cert.SubjectKeyId) {
+		problems = append(problems, fmt.Sprintf("authority key id of %s is %X, %s has subject key id %X", child, child.cert.AuthorityKeyId, issuer, issuer.cert.SubjectKeyId))
+	}
+	if err := child.cert.CheckSignatureFrom(issuer.cert); err != nil {
+		problems = append(problems, fmt.Sprintf("%s is not signed by %s: %v", child, issuer, err))
+	}
+	if issuer.cert.KeyUsage != 0 && issuer.cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		problems = append(problems, fmt.Sprintf("%s lacks the keyCertSign key usage", issuer))
+	}
+	// the depth-1 certificates after the leaf are the CAs below issuer
+	if issuer.cert.BasicConstraintsValid && (issuer.cert.MaxPathLen > 0 || issuer.cert.MaxPathLenZero) && depth > issuer.cert.MaxPathLen {
+		problems = append(problems, fmt.Sprintf("%s allows %d CAs below it, the path has %d", issuer, issuer.cert.MaxPathLen, depth))
+	}
+	if len(issuer.cert.ExtKeyUsage) > 0 && len(opts.KeyUsages) > 0 && opts.KeyUsages[0] != x509.ExtKeyUsageAny {
+		for _, usage := range opts.KeyUsages {
+			if !containsExtKeyUsage(issuer.cert.ExtKeyUsage, usage) {
+				problems = append(problems, fmt.Sprintf("%s does not allow %s", issuer, extKeyUsageList([]x509.ExtKeyUsage{usage})[0]))
+			}
+		}
+	}
+	return problems
+}
+
+func containsExtKeyUsage(usages []x509.ExtKeyUsage, usage x509.ExtKeyUsage) bool {
+	for _, u := range usages {
+		if u == usage || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+func validityProblem(c chainCert, now time.Time) string {
+	if now.Before(c.cert.NotBefore) {
+		return fmt.Sprintf("%s is not valid before %s", c, c.cert.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(c.cert.NotAfter) {
+		return fmt.Sprintf("%s expired at %s", c, c.cert.NotAfter.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// checkPath verifies path with x509, offering only its own certificates as
+// intermediates and its last one as the root.
+func checkPath(pool string, candidate candidatePath, opts VerifyOptions) ChainResult {
+	path := candidate.certs
+	result := ChainResult{Pool: pool}
+	for _, c := range path {
+		result.Path = append(result.Path, c.String())
+	}
+	if last := path[len(path)-1]; candidate.loop != nil {
+		result.Reasons = append(result.Reasons, fmt.Sprintf("the issuers of %s only lead back to %s, whose subject and key are already in the path", last, *candidate.loop))
+	} else if !candidate.anchored {
+		result.Reasons = append(result.Reasons, fmt.Sprintf("no issuer of %s, issued by %s, among the intermediates or the roots of %s", last, last.cert.Issuer, pool))
+	}
+	for i := range path {
+		if problem := validityProblem(path[i], opts.CurrentTime); problem != "" {
+			result.Reasons = append(result.Reasons, problem)
+		}
+		if i+1 < len(path) {
+			result.Reasons = append(result.Reasons, linkProblems(path, i, opts)...)
+		}
+	}
+	if !candidate.anchored {
+		return result
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(path[len(path)-1].cert)
+	for _, c := range path[1 : len(path)-1] {
+		intermediates.AddCert(c.cert)
+	}
+	chains, err := path[0].cert.Verify(x509.VerifyOptions{
+		DNSName:       opts.DNSName,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     opts.KeyUsages,
+		CurrentTime:   opts.CurrentTime,
+	})
+	if err != nil {
+		result.Reasons = append(result.Reasons, "x509: "+strings.TrimPrefix(err.Error(), "x509: "))
+		return result
+	}
+	for _, chain := range chains {
+		if len(chain) != len(path) {
+			continue
+		}
+		same := true
+		for i := range chain {
+			same = same && chain[i].Equal(path[i].cert)
+		}
+		if same {
+			result.Valid, result.Reasons = true, nil
+			return result
+		}
+	}
+	result.Reasons = append(result.Reasons, "x509 verifies the leaf through other paths only")
+	return result
+}
+
+// verifyChains checks every candidate path from leaf to each root pool.
+func verifyChains(leaf chainCert, intermediates []chainCert, pools []RootPool, opts VerifyOptions) []ChainResult {
+	if opts.CurrentTime.IsZero() {
+		opts.CurrentTime = time.Now()
+	}
+	var results []ChainResult
+	for _, pool := range pools {
+		for _, path := range candidatePaths(leaf, intermediates, pool.Roots) {
+			results = append(results, checkPath(pool.Name, path, opts))
+		}
+	}
+	return results
+}
+
+func writeChainResults(w io.Writer, results []ChainResult, format string) error {
+	switch format {
+	case "", "text":
+		for _, valid := range []bool{true, false} {
+			for _, result := range results {
+				if result.Valid != valid {
+					continue
+				}
+				status := "VALID"
+				if !valid {
+					status = "REJECTED"
+				}
+				fmt.Fprintf(w, "%s [%s] %s\n", status, result.Pool, strings.Join(result.Path, " -> "))
+				for _, reason := range result.Reasons {
+					fmt.Fprintf(w, "    %s\n", reason)
+				}
+			}
+		}
+		return nil
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if results == nil {
+			results = []ChainResult{}
+		}
+		return encoder.Encode(results)
+	default:
+		return fmt.Errorf("unsupported format %q, want text or json", format)
+	}
+}
+
+// poolFlags collects repeated -roots flags, one root pool each.
+type poolFlags []string
+
+func (p *poolFlags) String() string { return strings.Join(*p, " ") }
+
+func (p *poolFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+var extKeyUsagesByName = map[string]x509.ExtKeyUsage{
+	"any":        x509.ExtKeyUsageAny,
+	"serverAuth": x509.ExtKeyUsageServerAuth,
+	"clientAuth": x509.ExtKeyUsageClientAuth,
+}
+
+// runVerify lists every valid chain from a leaf to the root pools and why
+// the other candidate paths fail. It exits with status 1 when no chain is
+// valid.
+func runVerify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	leafFile := flags.String("leaf", "", "PEM leaf certificate, further certificates in the file are used as intermediates")
+	intermediateFiles := flags.String("intermediates", "", "comma separated globs of intermediate and cross certificates, e.g. conf/certs/ca??.crt")
+	var pools poolFlags
+	flags.Var(&pools, "roots", "comma separated globs of root certificates forming one pool, repeat for more pools")
+	usage := flags.String("usage", "any", "extended key usage the chain must allow: any, serverAuth or clientAuth")
+	dnsName := flags.String("dns", "", "also verify the leaf is valid for this host name")
+	at := flags.String("time", "", "verify at this RFC 3339 time instead of now")
+	format := flags.String("format", "text", "output format: text or json")
+	flags.Parse(args)
+	if *leafFile == "" || len(pools) == 0 {
+		log.Fatalf("usage: verify -leaf cert -roots roots [-roots roots...] [-intermediates certs]")
+	}
+	leafCerts, err := readChainCerts([]string{*leafFile})
+	if err != nil || len(leafCerts) == 0 {
+		log.Fatalf("read leaf %q failed, error %v", *leafFile, err)
+	}
+	intermediates, err := readChainCerts(strings.Split(*intermediateFiles, ","))
+	if err != nil {
+		log.Fatalf("read intermediates failed, error %v", err)
+	}
+	intermediates = append(leafCerts[1:], intermediates...)
+	var rootPools []RootPool
+	for _, pool := range pools {
+		roots, err := readChainCerts(strings.Split(pool, ","))
+		if err != nil {
+			log.Fatalf("read roots %q failed, error %v", pool, err)
+		}
+		rootPools = append(rootPools, RootPool{Name: pool, Roots: roots})
+	}
+	keyUsage, ok := extKeyUsagesByName[*usage]
+	if !ok {
+		log.Fatalf("unknown usage %q, want any, serverAuth or clientAuth", *usage)
+	}
+	opts := VerifyOptions{DNSName: *dnsName, KeyUsages: []x509.ExtKeyUsage{keyUsage}}
+	if *at != "" {
+		if opts.CurrentTime, err = time.Parse(time.RFC3339, *at); err != nil {
+			log.Fatalf("parse -time failed, error %v", err)
+		}
+	}
+	results := verifyChains(leafCerts[0], intermediates, rootPools, opts)
+	if err := writeChainResults(os.Stdout, results, *format); err != nil {
+		log.Fatalf("write report failed, error %v", err)
+	}
+	for _, result := range results {
+		if result.Valid {
+			return
+		}
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyChains(t *testing.T) {
+	dir := t.TempDir()
+	topology := &Topology{
+		OutputDir:     dir,
+		KeyAlgorithm:  "ecdsa-p256",
+		CAs:           []CASpec{{Name: "ca0"}, {Name: "ca1"}},
+		CrossSigns:    []CrossSignSpec{{Name: "ca01", Issuer: "ca0", Subject: "ca1"}, {Name: "ca10", Issuer: "ca1", Subject: "ca0"}},
+		Intermediates: []IntermediateSpec{{Name: "sub", Issuer: "ca1"}, {Name: "subsub", Issuer: "sub"}},
+		Leaves:        []LeafSpec{{Name: "server1", Issuer: "ca1"}, {Name: "deep", Issuer: "subsub"}},
+	}
+	if err := topology.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	certs := func(names ...string) []chainCert {
+		var patterns []string
+		for _, name := range names {
+			patterns = append(patterns, filepath.Join(dir, name+".crt"))
+		}
+		c, err := readChainCerts(patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	pools := []RootPool{{Name: "ca0", Roots: certs("ca0")}, {Name: "ca1", Roots: certs("ca1")}}
+
+	results := verifyChains(certs("server1")[0], certs("ca01", "ca10"), pools, VerifyOptions{})
+	valid := map[string]string{}
+	for _, result := range results {
+		if result.Valid {
+			valid[result.Pool] = strings.Join(result.Path, " -> ")
+		} else if len(result.Reasons) == 0 {
+			t.Errorf("rejected path %v has no reason", result.Path)
+		}
+	}
+	if len(valid) != 2 || !strings.Contains(valid["ca0"], "ca01.crt") || strings.Count(valid["ca1"], "->") != 1 {
+		t.Errorf("valid chains are %v", valid)
+	}
+
+	results = verifyChains(certs("deep")[0], certs("sub", "subsub"), pools[1:], VerifyOptions{})
+	if len(results) != 1 || results[0].Valid {
+		t.Fatalf("chains of deep are %+v", results)
+	}
+	if reasons := strings.Join(results[0].Reasons, "\n"); !strings.Contains(reasons, "allows 0 CAs below it") {
+		t.Errorf("path length violation not explained: %s", reasons)
+	}
+}
@@ -0,0 +1,285 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+// Authority is a CA that signs requests on demand, as the bootstrap server
+// does: its certificate and key, the openssl style database next to the
+// certificate and the issued certificates in <ca>.certs/<serial>.pem. Its
+// methods may be called concurrently.
+type Authority struct {
+	CertPath  string
+	Cert      *x509.Certificate
+	CertBlock []byte
+	// Chain are the PEM certificates above Cert up to the root, shipped
+	// with every issued certificate.
+	Chain  [][]byte
+	Signer crypto.Signer
+	// Policy is checked before each request is signed. Without one only
+	// the DefaultProfiles are issued.
+	Policy *Policy
+	// CRLDays is the validity of the CRL written after a revocation, 30
+	// days when 0.
+	CRLDays int
+
+	mu sync.Mutex
+}
+
+// LoadAuthority reads the CA certificate at certPath and opens its key
+// with OpenSigner, password decrypts an encrypted key or is the PIN of a
+// pkcs11: URI. Certificates following the first one in certPath become the
+// chain.
+func LoadAuthority(certPath, keyBackend, key, password string) (*Authority, error) {
+	signer, err := OpenSigner(keyBackend, key, password)
+	if err != nil {
+		return nil, err
+	}
+	authority, err := NewAuthority(certPath, signer)
+	if err != nil {
+		signer.Close()
+		return nil, err
+	}
+	return authority, nil
+}
+
+// NewAuthority reads the CA certificate at certPath and checks that signer
+// holds its key.
+func NewAuthority(certPath string, signer crypto.Signer) (*Authority, error) {
+	content, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("read ca cert %q failed, error %v", certPath, err)
+	}
+	blocks := splitCertificates(content)
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("ca cert %q holds no certificate", certPath)
+	}
+	cert, err := ParseCertificate(blocks[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse ca cert %q failed, error %v", certPath, err)
+	}
+	if !publicKeyEqual(cert.PublicKey, signer.Public()) {
+		return nil, fmt.Errorf("ca key does not match ca cert %q", certPath)
+	}
+	return &Authority{CertPath: certPath, Cert: cert, CertBlock: blocks[0], Chain: blocks[1:], Signer: signer}, nil
+}
+
+// Close releases the key backend of the signer.
+func (a *Authority) Close() error {
+	if closer, ok := a.Signer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// splitCertificates returns every CERTIFICATE block of content PEM encoded
+// on its own.
+func splitCertificates(content []byte) [][]byte {
+	var blocks [][]byte
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			return blocks
+		}
+		if block.Type == "CERTIFICATE" {
+			blocks = append(blocks, pem.EncodeToMemory(block))
+		}
+	}
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// AddChain appends the PEM certificates in path to the chain.
+func (a *Authority) AddChain(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read ca chain %q failed, error %v", path, err)
+	}
+	blocks := splitCertificates(content)
+	if len(blocks) == 0 {
+		return fmt.Errorf("ca chain %q holds no certificate", path)
+	}
+	a.Chain = append(a.Chain, blocks...)
+	return nil
+}
+
+// ChainPEM is the CA certificate followed by its chain.
+func (a *Authority) ChainPEM() []byte {
+	return bytes.Join(append([][]byte{a.CertBlock}, a.Chain...), nil)
+}
+
+// DefaultProfiles are the profiles an Authority without a Policy issues,
+// and a Policy without Profiles allows, never a CA or OCSP signer
+// certificate.
+var DefaultProfiles = []string{"tls-server", "tls-client", "tls-peer"}
+
+// IssuedPath is where the certificate with serial is stored.
+func (a *Authority) IssuedPath(serial *big.Int) string {
+	return utils.CAPrefix(a.CertPath) + ".certs/" + utils.FormatSerial(serial) + ".pem"
+}
+
+// Issue checks csrBlock against the policy, or the profile against the
+// DefaultProfiles, signs it, stores the certificate and records it in the
+// database. opts.ValidityDays is
+// replaced by the validity the policy allows.
+func (a *Authority) Issue(random io.Reader, csrBlock []byte, opts CertOptions) ([]byte, *x509.Certificate, error) {
+	csr, err := ParseCSR(csrBlock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse csr failed, error %v", err)
+	}
+	if a.Policy != nil {
+		days, err := a.Policy.Check(csr, opts.Profile, opts.ValidityDays)
+		if err != nil {
+			return nil, nil, fmt.Errorf("csr of %q rejected by policy: %v", csr.Subject.String(), err)
+		}
+		opts.ValidityDays = days
+	} else if profile := profileName(opts.Profile); !containsFold(DefaultProfiles, profile) {
+		return nil, nil, fmt.Errorf("profile %s is not allowed without a ca policy, want one of %v", profile, DefaultProfiles)
+	}
+	certBlock, err := SignServerCert(random, csrBlock, a.CertBlock, a.Signer, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := ParseCertificate(certBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	path := a.IssuedPath(cert.SerialNumber)
+	if err := WriteFile(path, certBlock); err != nil {
+		return nil, nil, err
+	}
+	if err := RecordIssuance(a.CertPath, certBlock, path); err != nil {
+		return nil, nil, err
+	}
+	return certBlock, cert, nil
+}
+
+// Reenroll renews current, the certificate the client authenticated with,
+// for the CSR in csrBlock. As RFC 7030 requires for simplereenroll, current
+// must have been issued by this CA and not be revoked, and the CSR must
+// repeat its subject and subject alternative names, the key may change. The
+// profile of current is kept.
+func (a *Authority) Reenroll(random io.Reader, current *x509.Certificate, csrBlock []byte, opts CertOptions) ([]byte, *x509.Certificate, error) {
+	if err := current.CheckSignatureFrom(a.Cert); err != nil {
+		return nil, nil, fmt.Errorf("certificate %q was not issued by %q, error %v", current.Subject.String(), a.Cert.Subject.String(), err)
+	}
+	entries, err := a.Entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry := utils.FindIndexEntry(entries, current.SerialNumber); entry != nil && entry.Status == "R" {
+		return nil, nil, fmt.Errorf("certificate %s of %q is revoked", utils.FormatSerial(current.SerialNumber), current.Subject.String())
+	}
+	if err := CheckSameIdentity(csrBlock, current); err != nil {
+		return nil, nil, err
+	}
+	opts.Profile = ProfileOf(current)
+	return a.Issue(random, csrBlock, opts)
+}
+
+// CheckSameIdentity returns an error unless the CSR in csrBlock repeats the
+// subject and subject alternative names of current, in any order.
+func CheckSameIdentity(csrBlock []byte, current *x509.Certificate) error {
+	csr, err := ParseCSR(csrBlock)
+	if err != nil {
+		return fmt.Errorf("parse csr failed, error %v", err)
+	}
+	// compared as strings, openssl writes UTF8String where Go writes
+	// PrintableString
+	if csr.Subject.String() != current.Subject.String() {
+		return fmt.Errorf("csr subject %q differs from the subject %q of the current certificate", csr.Subject.String(), current.Subject.String())
+	}
+	if !sameNames(csr, current) {
+		return fmt.Errorf("csr subject alternative names differ from those of the current certificate")
+	}
+	return nil
+}
+
+// sameNames reports whether csr asks for exactly the SANs of cert, in any
+// order.
+func sameNames(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	names := func(dns []string, ips []net.IP, uris []*url.URL, emails []string) []string {
+		var all []string
+		for _, name := range dns {
+			all = append(all, "DNS:"+strings.ToLower(name))
+		}
+		for _, ip := range ips {
+			all = append(all, "IP:"+ip.String())
+		}
+		for _, uri := range uris {
+			all = append(all, "URI:"+uri.String())
+		}
+		for _, email := range emails {
+			all = append(all, "email:"+email)
+		}
+		slices.Sort(all)
+		return all
+	}
+	return slices.Equal(names(csr.DNSNames, csr.IPAddresses, csr.URIs, csr.EmailAddresses), names(cert.DNSNames, cert.IPAddresses, cert.URIs, cert.EmailAddresses))
+}
+
+// Entries returns the database of the CA.
+func (a *Authority) Entries() ([]*utils.IndexEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return utils.ReadIndex(utils.IndexPath(a.CertPath))
+}
+
+// Certificate returns the PEM certificate the CA issued with serial and
+// its database entry, os.ErrNotExist when the CA has no copy of it.
+func (a *Authority) Certificate(serial *big.Int) ([]byte, *utils.IndexEntry, error) {
+	entries, err := a.Entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := utils.FindIndexEntry(entries, serial)
+	if entry == nil || entry.File == "unknown" {
+		return nil, nil, os.ErrNotExist
+	}
+	certBlock, err := os.ReadFile(entry.File)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certBlock, entry, nil
+}
+
+// Revoke marks serial revoked and writes a fresh CRL.
+func (a *Authority) Revoke(serial *big.Int, reason string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var cert *x509.Certificate
+	if certBlock, err := os.ReadFile(a.IssuedPath(serial)); err == nil {
+		if cert, err = ParseCertificate(certBlock); err != nil {
+			return err
+		}
+	}
+	if err := RevokeCertificate(a.CertPath, a.Cert, serial, cert, reason, time.Now()); err != nil {
+		return err
+	}
+	crlDays := a.CRLDays
+	if crlDays == 0 {
+		crlDays = 30
+	}
+	return WriteCRL(a.CertPath, a.Cert, a.Signer, crlDays)
+}
@@ -0,0 +1,192 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+// newTestAuthority writes an ecdsa root as ca1.crt and ca1.key to dir
+// and loads it.
+func newTestAuthority(t *testing.T, dir string) *Authority {
+	caKey, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBlock, err := SignCACert(nil, caKey, "DevCA", CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBlock, err := utils.EncodePrivateKey(caKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertPath, caKeyPath := filepath.Join(dir, "ca1.crt"), filepath.Join(dir, "ca1.key")
+	if err := WriteFile(caCertPath, caBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(caKeyPath, keyBlock); err != nil {
+		t.Fatal(err)
+	}
+	authority, err := LoadAuthority(caCertPath, "", caKeyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authority
+}
+
+func TestAuthority(t *testing.T) {
+	dir := t.TempDir()
+	authority := newTestAuthority(t, dir)
+
+	key, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, err := CreateCSR(nil, key, CSROptions{Subject: pkix.Name{CommonName: "node1"}, SANs: SANs{DNSNames: []string{"node1.dev.local"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a policy no CA or OCSP signer certificates are issued
+	plain := newTestAuthority(t, t.TempDir())
+	for _, profile := range []string{"sub-ca", "root-ca", "ocsp-signer", "code-signing"} {
+		if _, _, err := plain.Issue(nil, good, CertOptions{Profile: profile}); err == nil {
+			t.Errorf("expected profile %q to be rejected without a policy", profile)
+		}
+	}
+	if _, _, err := plain.Issue(nil, good, CertOptions{Profile: "client"}); err != nil {
+		t.Errorf("tls-client rejected without a policy, error %v", err)
+	}
+
+	authority.Policy = &Policy{DNSSuffixes: []string{"dev.local"}, MaxValidityDays: 30}
+
+	bad, err := CreateCSR(nil, key, CSROptions{Subject: pkix.Name{CommonName: "node1"}, SANs: SANs{DNSNames: []string{"node1.example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := authority.Issue(nil, bad, CertOptions{}); err == nil {
+		t.Error("expected the policy to reject a dns name outside dev.local")
+	}
+	certBlock, cert, err := authority.Issue(nil, good, CertOptions{Profile: "tls-server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if days := cert.NotAfter.Sub(cert.NotBefore).Hours() / 24; days != 30 {
+		t.Errorf("certificate is valid %v days, want the policy maximum of 30", days)
+	}
+	if err := cert.CheckSignatureFrom(authority.Cert); err != nil {
+		t.Errorf("certificate not signed by the ca, error %v", err)
+	}
+	stored, entry, err := authority.Certificate(cert.SerialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, certBlock) || entry.Status != "V" {
+		t.Errorf("stored certificate differs or has status %q", entry.Status)
+	}
+	if _, _, err := authority.Certificate(authority.Cert.SerialNumber); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist for a serial the ca did not issue, got %v", err)
+	}
+
+	if err := authority.Revoke(cert.SerialNumber, "keyCompromise"); err != nil {
+		t.Fatal(err)
+	}
+	if err := authority.Revoke(cert.SerialNumber, "keyCompromise"); !errors.Is(err, ErrAlreadyRevoked) {
+		t.Errorf("expected ErrAlreadyRevoked revoking the same serial twice, got %v", err)
+	}
+	entries, err := authority.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Status != "R" || entries[0].Reason != "keyCompromise" {
+		t.Errorf("database entries are %v, want one revoked entry", entries)
+	}
+	der, err := os.ReadFile(filepath.Join(dir, "ca1.crl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("crl entries are %v, want the issued serial", crl.RevokedCertificateEntries)
+	}
+
+	caCertPath, caKeyPath := filepath.Join(dir, "ca1.crt"), filepath.Join(dir, "ca1.key")
+	otherKey, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBlock, _ := utils.EncodePrivateKey(otherKey, "")
+	if err := WriteFile(caKeyPath, otherBlock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuthority(caCertPath, "", caKeyPath, ""); err == nil {
+		t.Error("expected an error loading a ca with a key that does not match")
+	}
+}
+
+func TestAuthorityReenroll(t *testing.T) {
+	authority := newTestAuthority(t, t.TempDir())
+	key, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: []string{"lab"}}, SANs: SANs{DNSNames: []string{"node1.dev.local", "node1"}}}
+	csr, err := CreateCSR(nil, key, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, current, err := authority.Issue(nil, csr, CertOptions{Profile: "tls-client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reordered := names
+	reordered.DNSNames = []string{"node1", "node1.dev.local"}
+	renewal, err := CreateCSR(nil, newKey, reordered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, renewed, err := authority.Reenroll(nil, current, renewal, CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.SerialNumber.Cmp(current.SerialNumber) == 0 || ProfileOf(renewed) != "tls-client" {
+		t.Errorf("renewed certificate has serial %v and profile %s, want a new serial and tls-client", renewed.SerialNumber, ProfileOf(renewed))
+	}
+
+	other := names
+	other.Subject.CommonName = "node2"
+	otherSubject, _ := CreateCSR(nil, newKey, other)
+	if _, _, err := authority.Reenroll(nil, current, otherSubject, CertOptions{}); err == nil {
+		t.Error("expected an error re-enrolling with a different subject")
+	}
+	moreNames := names
+	moreNames.DNSNames = append(moreNames.DNSNames, "admin.dev.local")
+	otherNames, _ := CreateCSR(nil, newKey, moreNames)
+	if _, _, err := authority.Reenroll(nil, current, otherNames, CertOptions{}); err == nil {
+		t.Error("expected an error re-enrolling with additional names")
+	}
+	if err := authority.Revoke(current.SerialNumber, "superseded"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := authority.Reenroll(nil, current, renewal, CertOptions{}); err == nil {
+		t.Error("expected an error re-enrolling with a revoked certificate")
+	}
+	foreign := newTestAuthority(t, t.TempDir())
+	if _, _, err := foreign.Reenroll(nil, renewed, renewal, CertOptions{}); err == nil {
+		t.Error("expected an error re-enrolling a certificate of another ca")
+	}
+}
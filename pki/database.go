@@ -0,0 +1,140 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+// StartIndex gives a freshly generated CA an empty database, entries left
+// from an earlier CA written to the same path no longer apply.
+func StartIndex(caCertPath string) error {
+	return utils.WriteIndex(utils.IndexPath(caCertPath), nil)
+}
+
+// RecordIssuance appends the certificate written to file to the database of
+// the CA at caCertPath.
+func RecordIssuance(caCertPath string, certBlock []byte, file string) error {
+	cert, err := ParseCertificate(certBlock)
+	if err != nil {
+		return fmt.Errorf("parse issued certificate %q failed, error %v", file, err)
+	}
+	path := utils.IndexPath(caCertPath)
+	entries, err := utils.ReadIndex(path)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, utils.NewIndexEntry(cert, file))
+	return utils.WriteIndex(path, entries)
+}
+
+// ErrAlreadyRevoked is returned when revoking a serial a second time.
+var ErrAlreadyRevoked = errors.New("already revoked")
+
+// RevokeCertificate marks serial revoked in the CA database. cert, when
+// known, fills in expiry and subject for serials that were never recorded.
+func RevokeCertificate(caCertPath string, caCert *x509.Certificate, serial *big.Int, cert *x509.Certificate, reason string, revokedAt time.Time) error {
+	reasonCode, err := utils.RevocationReasonCode(reason)
+	if err != nil {
+		return err
+	}
+	path := utils.IndexPath(caCertPath)
+	entries, err := utils.ReadIndex(path)
+	if err != nil {
+		return err
+	}
+	entry := utils.FindIndexEntry(entries, serial)
+	if entry == nil {
+		if cert != nil {
+			entry = utils.NewIndexEntry(cert, "unknown")
+		} else {
+			entry = &utils.IndexEntry{Expiry: caCert.NotAfter, Serial: serial, File: "unknown", Subject: "unknown"}
+		}
+		entries = append(entries, entry)
+	} else if entry.Status == "R" {
+		return fmt.Errorf("serial %s is %w", utils.FormatSerial(serial), ErrAlreadyRevoked)
+	}
+	entry.Status = "R"
+	entry.RevocationTime = revokedAt
+	if reasonCode != 0 {
+		entry.Reason = utils.RevocationReasonName(reasonCode)
+	}
+	return utils.WriteIndex(path, entries)
+}
+
+// nextCRLNumber reads, increments and stores the openssl style crlnumber
+// file next to the CA.
+func nextCRLNumber(caCertPath string) (*big.Int, error) {
+	path := utils.CAPrefix(caCertPath) + ".crlnumber"
+	number := big.NewInt(1)
+	if content, err := os.ReadFile(path); err == nil {
+		if _, ok := number.SetString(strings.TrimSpace(string(content)), 16); !ok {
+			return nil, fmt.Errorf("invalid crl number in %q", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	next := new(big.Int).Add(number, big.NewInt(1))
+	if err := WriteFile(path, []byte(utils.FormatSerial(next)+"\n")); err != nil {
+		return nil, err
+	}
+	return number, nil
+}
+
+// CreateCRL signs a CRL listing every revoked entry of the database.
+func CreateCRL(caCert *x509.Certificate, caPrivateKey crypto.Signer, entries []*utils.IndexEntry, number *big.Int, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	revoked := make([]x509.RevocationListEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Status != "R" {
+			continue
+		}
+		reasonCode, err := utils.RevocationReasonCode(entry.Reason)
+		if err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   entry.Serial,
+			RevocationTime: entry.RevocationTime,
+			ReasonCode:     reasonCode,
+		})
+	}
+	template := &x509.RevocationList{
+		SignatureAlgorithm:        SignatureAlgorithm(caPrivateKey),
+		RevokedCertificateEntries: revoked,
+		Number:                    number,
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                nextUpdate,
+	}
+	return x509.CreateRevocationList(randReader(nil), template, caCert, caPrivateKey)
+}
+
+// WriteCRL issues a fresh CRL from the CA database and stores it as
+// <ca>.crl (DER) and <ca>.crl.pem.
+func WriteCRL(caCertPath string, caCert *x509.Certificate, caPrivateKey crypto.Signer, crlDays int) error {
+	entries, err := utils.ReadIndex(utils.IndexPath(caCertPath))
+	if err != nil {
+		return err
+	}
+	number, err := nextCRLNumber(caCertPath)
+	if err != nil {
+		return err
+	}
+	thisUpdate := time.Now()
+	der, err := CreateCRL(caCert, caPrivateKey, entries, number, thisUpdate, thisUpdate.AddDate(0, 0, crlDays))
+	if err != nil {
+		return fmt.Errorf("create crl for %q failed, error %v", caCertPath, err)
+	}
+	prefix := utils.CAPrefix(caCertPath)
+	if err := WriteFile(prefix+".crl", der); err != nil {
+		return err
+	}
+	return WriteFile(prefix+".crl.pem", pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}))
+}
@@ -0,0 +1,153 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"strings"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+// DefaultKeyAlgorithm is used when no key algorithm is given.
+const DefaultKeyAlgorithm = "rsa4096"
+
+// KeyAlgorithms lists the values accepted wherever a key algorithm is chosen.
+var KeyAlgorithms = []string{"rsa2048", "rsa3072", "rsa4096", "ecdsa-p256", "ecdsa-p384", "ed25519"}
+
+var keyAlgorithmAliases = map[string]string{
+	"":      DefaultKeyAlgorithm,
+	"rsa":   DefaultKeyAlgorithm,
+	"ecdsa": "ecdsa-p256",
+	"p256":  "ecdsa-p256",
+	"p384":  "ecdsa-p384",
+}
+
+// NormalizeKeyAlgorithm maps aliases and case variants onto KeyAlgorithms.
+func NormalizeKeyAlgorithm(algorithm string) (string, error) {
+	algorithm = strings.ToLower(algorithm)
+	if alias, ok := keyAlgorithmAliases[algorithm]; ok {
+		return alias, nil
+	}
+	for _, known := range KeyAlgorithms {
+		if algorithm == known {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported key algorithm %q, want one of %v", algorithm, KeyAlgorithms)
+}
+
+// GenerateKey creates a key of one of the KeyAlgorithms, reading from
+// random, crypto/rand when nil.
+func GenerateKey(random io.Reader, algorithm string) (crypto.Signer, error) {
+	algorithm, err := NormalizeKeyAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	random = randReader(random)
+	switch algorithm {
+	case "rsa2048":
+		return rsa.GenerateKey(random, 2048)
+	case "rsa3072":
+		return rsa.GenerateKey(random, 3072)
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), random)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), random)
+	case "ed25519":
+		_, privateKey, err := ed25519.GenerateKey(random)
+		return privateKey, err
+	default:
+		return rsa.GenerateKey(random, 4096)
+	}
+}
+
+// GeneratePrivateKey creates a key like GenerateKey and returns it with its
+// PKCS#8 PEM encoding, encrypted when password is not empty.
+func GeneratePrivateKey(random io.Reader, algorithm, password string) (crypto.Signer, []byte, error) {
+	privateKey, err := GenerateKey(random, algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKeyBlock, err := utils.EncodePrivateKey(privateKey, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode private key failed, error %v", err)
+	}
+	return privateKey, privateKeyBlock, nil
+}
+
+func randReader(random io.Reader) io.Reader {
+	if random == nil {
+		return rand.Reader
+	}
+	return random
+}
+
+// SignatureAlgorithm picks the signature matching the issuer key, so an
+// ECDSA CA never tries to sign with SHA256WithRSA because the subject was RSA.
+func SignatureAlgorithm(signer crypto.Signer) x509.SignatureAlgorithm {
+	switch publicKey := signer.Public().(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		if publicKey.Curve == elliptic.P384() {
+			return x509.ECDSAWithSHA384
+		}
+		return x509.ECDSAWithSHA256
+	case ed25519.PublicKey:
+		return x509.PureEd25519
+	default:
+		return x509.UnknownSignatureAlgorithm
+	}
+}
+
+// keyEnciphermentUsage only grants KeyEncipherment to RSA subjects, the
+// other key types cannot be used for RSA key transport.
+func keyEnciphermentUsage(publicKey crypto.PublicKey) x509.KeyUsage {
+	if _, ok := publicKey.(*rsa.PublicKey); ok {
+		return x509.KeyUsageKeyEncipherment
+	}
+	return 0
+}
+
+// SubjectKeyID is the RFC 5280 method 1 key identifier, the SHA-1 hash of
+// the subjectPublicKey bit string.
+func SubjectKeyID(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &publicKeyInfo); err != nil {
+		return nil, err
+	}
+	id := sha1.Sum(publicKeyInfo.PublicKey.Bytes)
+	return id[:], nil
+}
+
+// KeyAlgorithmOf names the algorithm of publicKey as in KeyAlgorithms, so a
+// replacement key can be generated with the same type and size.
+func KeyAlgorithmOf(publicKey crypto.PublicKey) (string, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return NormalizeKeyAlgorithm(fmt.Sprintf("rsa%d", key.N.BitLen()))
+	case *ecdsa.PublicKey:
+		return NormalizeKeyAlgorithm("ecdsa-" + strings.ReplaceAll(strings.ToLower(key.Curve.Params().Name), "-", ""))
+	case ed25519.PublicKey:
+		return "ed25519", nil
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
@@ -0,0 +1,239 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyShare is one of the shares a CA key is split into, Threshold of the
+// Total shares recombine the PKCS#8 key whose subject key id is KeyID.
+type KeyShare struct {
+	Index     int
+	Threshold int
+	Total     int
+	KeyID     []byte
+	Value     []byte
+}
+
+// SplitKey splits privateKey into total shares, any threshold of which
+// recombine it with CombineKey.
+func SplitKey(random io.Reader, privateKey crypto.Signer, threshold, total int) ([]KeyShare, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key failed, error %v", err)
+	}
+	keyID, err := SubjectKeyID(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	values, err := splitSecret(random, der, threshold, total)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]KeyShare, total)
+	for i, value := range values {
+		shares[i] = KeyShare{Index: i + 1, Threshold: threshold, Total: total, KeyID: keyID, Value: value}
+	}
+	return shares, nil
+}
+
+// CombineKey recombines the key of shares, which must be at least the
+// threshold of distinct shares of the same key.
+func CombineKey(shares []KeyShare) (crypto.Signer, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no key shares given")
+	}
+	first := shares[0]
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("key %x needs %d of its %d shares, got %d", first.KeyID, first.Threshold, first.Total, len(shares))
+	}
+	xs := make([]byte, len(shares))
+	values := make([][]byte, len(shares))
+	for i, share := range shares {
+		if !bytes.Equal(share.KeyID, first.KeyID) || share.Threshold != first.Threshold || share.Total != first.Total {
+			return nil, fmt.Errorf("share %d of key %x does not belong to key %x", share.Index, share.KeyID, first.KeyID)
+		}
+		if share.Index < 1 || share.Index > share.Total {
+			return nil, fmt.Errorf("share index %d is out of range", share.Index)
+		}
+		xs[i], values[i] = byte(share.Index), share.Value
+	}
+	der, err := combineSecret(xs, values)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("recombined key %x is invalid, a share is corrupt", first.KeyID)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if keyID, err := SubjectKeyID(signer.Public()); err != nil || !bytes.Equal(keyID, first.KeyID) {
+		return nil, fmt.Errorf("recombined key does not match key id %x, a share is corrupt", first.KeyID)
+	}
+	return signer, nil
+}
+
+const keySharePEMType = "CA KEY SHARE"
+
+// EncodeKeyShare returns share as a CA KEY SHARE PEM block. With a non
+// empty password the value is sealed with AES-256-GCM under a scrypt
+// derived key, the headers are authenticated with it.
+func EncodeKeyShare(random io.Reader, share KeyShare, password string) ([]byte, error) {
+	block := &pem.Block{
+		Type: keySharePEMType,
+		Headers: map[string]string{
+			"Key-ID":    hex.EncodeToString(share.KeyID),
+			"Index":     strconv.Itoa(share.Index),
+			"Threshold": strconv.Itoa(share.Threshold),
+			"Shares":    strconv.Itoa(share.Total),
+		},
+		Bytes: share.Value,
+	}
+	if password != "" {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(randReader(random), salt); err != nil {
+			return nil, fmt.Errorf("read salt failed, error %v", err)
+		}
+		aead, err := shareCipher(password, salt)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(randReader(random), nonce); err != nil {
+			return nil, fmt.Errorf("read nonce failed, error %v", err)
+		}
+		block.Headers["Encryption"] = "scrypt-aes-256-gcm"
+		block.Headers["Salt"] = hex.EncodeToString(salt)
+		block.Bytes = aead.Seal(nonce, nonce, share.Value, shareHeaders(block))
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func shareCipher(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive share key failed, error %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// shareHeaders is the additional data binding an encrypted value to its
+// share.
+func shareHeaders(block *pem.Block) []byte {
+	return []byte(block.Headers["Key-ID"] + "/" + block.Headers["Index"] + "/" + block.Headers["Threshold"] + "/" + block.Headers["Shares"])
+}
+
+// DecodeKeyShare parses a CA KEY SHARE PEM block, decrypting it with
+// password when it is encrypted.
+func DecodeKeyShare(content []byte, password string) (KeyShare, error) {
+	var share KeyShare
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != keySharePEMType {
+		return share, errors.New("no key share PEM block found")
+	}
+	var err error
+	if share.KeyID, err = hex.DecodeString(block.Headers["Key-ID"]); err != nil || len(share.KeyID) == 0 {
+		return share, errors.New("key share has no valid Key-ID")
+	}
+	for name, value := range map[string]*int{"Index": &share.Index, "Threshold": &share.Threshold, "Shares": &share.Total} {
+		if *value, err = strconv.Atoi(block.Headers[name]); err != nil {
+			return share, fmt.Errorf("key share has no valid %s", name)
+		}
+	}
+	share.Value = block.Bytes
+	switch block.Headers["Encryption"] {
+	case "":
+	case "scrypt-aes-256-gcm":
+		if password == "" {
+			return share, errors.New("key share is encrypted but no password was given")
+		}
+		salt, err := hex.DecodeString(block.Headers["Salt"])
+		if err != nil {
+			return share, errors.New("key share has no valid Salt")
+		}
+		aead, err := shareCipher(password, salt)
+		if err != nil {
+			return share, err
+		}
+		if len(block.Bytes) < aead.NonceSize() {
+			return share, errors.New("key share is truncated")
+		}
+		nonce, sealed := block.Bytes[:aead.NonceSize()], block.Bytes[aead.NonceSize():]
+		if share.Value, err = aead.Open(nil, nonce, sealed, shareHeaders(block)); err != nil {
+			return share, errors.New("decrypt key share failed, wrong password or modified share")
+		}
+	default:
+		return share, fmt.Errorf("unsupported key share encryption %q", block.Headers["Encryption"])
+	}
+	return share, nil
+}
+
+// ReadKeyShare reads and decodes the key share stored at path.
+func ReadKeyShare(path, password string) (KeyShare, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return KeyShare{}, err
+	}
+	share, err := DecodeKeyShare(content, password)
+	if err != nil {
+		return share, fmt.Errorf("read key share %q failed, error %v", path, err)
+	}
+	return share, nil
+}
+
+// ReadKeyShares reads the share files at paths. A share is decrypted with
+// the password at the same position, a single password is used for every
+// share.
+func ReadKeyShares(paths, passwords []string) ([]KeyShare, error) {
+	if len(passwords) > 1 && len(passwords) != len(paths) {
+		return nil, fmt.Errorf("got %d share passwords for %d shares, want one or one per share", len(passwords), len(paths))
+	}
+	shares := make([]KeyShare, len(paths))
+	for i, path := range paths {
+		password := ""
+		if len(passwords) == 1 {
+			password = passwords[0]
+		} else if len(passwords) > 1 {
+			password = passwords[i]
+		}
+		share, err := ReadKeyShare(path, password)
+		if err != nil {
+			return nil, err
+		}
+		shares[i] = share
+	}
+	return shares, nil
+}
+
+// OpenShareSigner recombines the key of the share files at paths, read
+// with ReadKeyShares. The key exists in memory only until Close.
+func OpenShareSigner(paths, passwords []string) (KeySigner, error) {
+	shares, err := ReadKeyShares(paths, passwords)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := CombineKey(shares)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSigner{Signer: signer}, nil
+}
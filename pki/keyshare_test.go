@@ -0,0 +1,123 @@
+package pki
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShamir(t *testing.T) {
+	secret := []byte("root key material \x00\xff")
+	shares, err := splitSecret(nil, secret, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var xs []byte
+		var values [][]byte
+		for _, i := range subset {
+			xs, values = append(xs, byte(i+1)), append(values, shares[i])
+		}
+		got, err := combineSecret(xs, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Errorf("shares %v recombined %q", subset, got)
+		}
+	}
+	// two shares of a 3-of-5 split interpolate a line, not the secret
+	got, err := combineSecret([]byte{1, 2}, shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, secret) {
+		t.Error("two shares recovered a 3-of-5 secret")
+	}
+	for _, c := range [][2]int{{1, 3}, {4, 3}, {3, 256}} {
+		if _, err := splitSecret(nil, secret, c[0], c[1]); err == nil {
+			t.Errorf("expected an error splitting %d-of-%d", c[0], c[1])
+		}
+	}
+}
+
+func TestKeyShares(t *testing.T) {
+	dir := t.TempDir()
+	key, err := GenerateKey(nil, "ecdsa-p384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitKey(nil, key, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths, passwords []string
+	for _, share := range shares {
+		password := fmt.Sprintf("operator%d", share.Index)
+		content, err := EncodeKeyShare(nil, share, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(content, share.Value) {
+			t.Error("encrypted share holds its plain value")
+		}
+		path := filepath.Join(dir, fmt.Sprintf("ca0.share%d.pem", share.Index))
+		if err := WriteFile(path, content); err != nil {
+			t.Fatal(err)
+		}
+		paths, passwords = append(paths, path), append(passwords, password)
+	}
+
+	signer, err := OpenShareSigner([]string{paths[2], paths[0]}, []string{passwords[2], passwords[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKeyEqual(key.Public(), signer.Public()) {
+		t.Error("recombined another key")
+	}
+	if _, err := OpenShareSigner(paths[:1], passwords[:1]); err == nil || !strings.Contains(err.Error(), "needs 2") {
+		t.Errorf("expected one share to be too few, error %v", err)
+	}
+	if _, err := OpenShareSigner(paths[:2], []string{passwords[1], passwords[0]}); err == nil {
+		t.Error("expected swapped passwords to be rejected")
+	}
+	if _, err := OpenShareSigner([]string{paths[0], paths[0]}, []string{passwords[0]}); err == nil {
+		t.Error("expected the same share twice to be rejected")
+	}
+
+	other, err := GenerateKey(nil, "ecdsa-p384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherShares, err := SplitKey(nil, other, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineKey([]KeyShare{shares[0], otherShares[1]}); err == nil {
+		t.Error("expected shares of different keys to be rejected")
+	}
+	corrupt := shares[1]
+	corrupt.Value = bytes.Clone(corrupt.Value)
+	corrupt.Value[10] ^= 1
+	if _, err := CombineKey([]KeyShare{shares[0], corrupt}); err == nil {
+		t.Error("expected a corrupt share to be detected")
+	}
+
+	plain, err := EncodeKeyShare(nil, shares[1], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeKeyShare(plain, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recombined, err := CombineKey([]KeyShare{decoded, shares[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKeyEqual(key.Public(), recombined.Public()) {
+		t.Error("plain share recombined another key")
+	}
+}
@@ -0,0 +1,176 @@
+package pki
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SANs are the subject alternative names of a certificate or request.
+type SANs struct {
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	EmailAddresses []string
+}
+
+// Empty reports whether no name of any type is set.
+func (s SANs) Empty() bool {
+	return len(s.DNSNames) == 0 && len(s.IPAddresses) == 0 && len(s.URIs) == 0 && len(s.EmailAddresses) == 0
+}
+
+// ParseSANs reads names in the openssl subjectAltName form, DNS:, IP:, URI:
+// or email: followed by the value. Untyped values are taken as an IP
+// address when they parse as one, a URI when they have a scheme, an email
+// address when they hold an @ and a DNS name otherwise.
+func ParseSANs(values []string) (SANs, error) {
+	var sans SANs
+	for _, value := range values {
+		kind, name, typed := strings.Cut(value, ":")
+		if !typed || !knownSANType(kind) {
+			kind, name = guessSANType(value), value
+		}
+		switch strings.ToLower(kind) {
+		case "dns":
+			if err := checkDNSName(name); err != nil {
+				return SANs{}, err
+			}
+			sans.DNSNames = append(sans.DNSNames, name)
+		case "ip":
+			ip := net.ParseIP(name)
+			if ip == nil {
+				return SANs{}, fmt.Errorf("invalid ip address %q", name)
+			}
+			sans.IPAddresses = append(sans.IPAddresses, ip)
+		case "uri":
+			uri, err := url.Parse(name)
+			if err != nil || uri.Scheme == "" {
+				return SANs{}, fmt.Errorf("invalid uri %q", name)
+			}
+			sans.URIs = append(sans.URIs, uri)
+		case "email":
+			if _, err := mail.ParseAddress(name); err != nil {
+				return SANs{}, fmt.Errorf("invalid email address %q", name)
+			}
+			sans.EmailAddresses = append(sans.EmailAddresses, name)
+		}
+	}
+	return sans, nil
+}
+
+func knownSANType(kind string) bool {
+	switch strings.ToLower(kind) {
+	case "dns", "ip", "uri", "email":
+		return true
+	}
+	return false
+}
+
+func guessSANType(value string) string {
+	switch {
+	case net.ParseIP(value) != nil:
+		return "ip"
+	case strings.Contains(value, "://"):
+		return "uri"
+	case strings.Contains(value, "@"):
+		return "email"
+	default:
+		return "dns"
+	}
+}
+
+// checkDNSName accepts host names and wildcards, where the * must be the
+// whole leftmost label of a name with at least two more labels.
+func checkDNSName(name string) error {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if label == "*" && i == 0 {
+			if len(labels) < 3 {
+				return fmt.Errorf("wildcard %q must cover a subdomain of a registered domain", name)
+			}
+			continue
+		}
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid dns name %q", name)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid dns name %q, wildcards are only allowed as the whole leftmost label", name)
+			}
+		}
+	}
+	return nil
+}
+
+// subjectAttributes maps the short names of openssl -subj onto pkix.Name.
+var subjectAttributes = map[string]func(name *pkix.Name, value string){
+	"C":            func(name *pkix.Name, value string) { name.Country = append(name.Country, value) },
+	"ST":           func(name *pkix.Name, value string) { name.Province = append(name.Province, value) },
+	"L":            func(name *pkix.Name, value string) { name.Locality = append(name.Locality, value) },
+	"O":            func(name *pkix.Name, value string) { name.Organization = append(name.Organization, value) },
+	"OU":           func(name *pkix.Name, value string) { name.OrganizationalUnit = append(name.OrganizationalUnit, value) },
+	"STREET":       func(name *pkix.Name, value string) { name.StreetAddress = append(name.StreetAddress, value) },
+	"POSTALCODE":   func(name *pkix.Name, value string) { name.PostalCode = append(name.PostalCode, value) },
+	"SERIALNUMBER": func(name *pkix.Name, value string) { name.SerialNumber = value },
+	"CN":           func(name *pkix.Name, value string) { name.CommonName = value },
+}
+
+// ParseSubject reads a distinguished name in the openssl -subj form,
+// /C=CN/ST=BeiJing/O=devCompany/CN=DevCA, or comma separated as printed by
+// openssl x509 -subject, C=CN, O=devCompany, CN=DevCA. Attributes may
+// repeat except CN and serialNumber.
+func ParseSubject(s string) (pkix.Name, error) {
+	var name pkix.Name
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return name, nil
+	}
+	separator := ","
+	if strings.HasPrefix(s, "/") {
+		separator, s = "/", s[1:]
+	}
+	for _, part := range strings.Split(s, separator) {
+		key, value, ok := strings.Cut(part, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		set, known := subjectAttributes[strings.ToUpper(key)]
+		if !ok || !known {
+			return pkix.Name{}, fmt.Errorf("invalid subject attribute %q in %q, want one of C, ST, L, O, OU, street, postalCode, serialNumber or CN", part, s)
+		}
+		set(&name, value)
+	}
+	return name, nil
+}
+
+// ParseDuration is time.ParseDuration with a d suffix for days, 90d.
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// ParseTime reads an RFC 3339 time or a date, 2006-01-02, taken as
+// midnight UTC.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want RFC 3339 or 2006-01-02", s)
+	}
+	return t, nil
+}
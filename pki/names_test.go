@@ -0,0 +1,45 @@
+package pki
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNames(t *testing.T) {
+	subject, err := ParseSubject("/C=CN/ST=BeiJing/O=devCompany/OU=devTeam/OU=ops/CN=DevCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject.CommonName != "DevCA" || len(subject.OrganizationalUnit) != 2 || subject.Province[0] != "BeiJing" {
+		t.Errorf("parsed subject %+v", subject)
+	}
+	if subject, err = ParseSubject("CN=api, O=devCompany"); err != nil || subject.CommonName != "api" || subject.Organization[0] != "devCompany" {
+		t.Errorf("parsed comma separated subject %+v, error %v", subject, err)
+	}
+	if _, err := ParseSubject("/X=1"); err == nil {
+		t.Error("expected an error for an unknown attribute")
+	}
+
+	sans, err := ParseSANs([]string{"DNS:*.dev.local", "api.dev.local", "10.0.0.1", "IP:::1", "URI:spiffe://dev.local/api", "ops@dev.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sans.DNSNames) != 2 || len(sans.IPAddresses) != 2 || len(sans.URIs) != 1 || len(sans.EmailAddresses) != 1 {
+		t.Errorf("parsed SANs %+v", sans)
+	}
+	for _, bad := range []string{"DNS:a.*.dev.local", "DNS:*.local", "DNS:*dev.local", "IP:300.0.0.1", "email:nobody"} {
+		if _, err := ParseSANs([]string{bad}); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+
+	if d, err := ParseDuration("90d"); err != nil || d != 90*24*time.Hour {
+		t.Errorf("90d is %v, error %v", d, err)
+	}
+	if _, err := ParseDuration("-1h"); err == nil {
+		t.Error("expected an error for a negative duration")
+	}
+	if ts, err := ParseTime("2024-02-29"); err != nil || ts.Day() != 29 {
+		t.Errorf("2024-02-29 is %v, error %v", ts, err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package pki issues the development certificates: key generation, CSRs,
+// root, intermediate, cross and leaf signing and their PEM files. Every
+// function returns its error instead of exiting, so the cert generator, the
+// bootstrap server and tests can all issue certificates in-process.
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// EncodeCertificate wraps a DER certificate in a CERTIFICATE PEM block.
+func EncodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// ParseCertificate parses the first CERTIFICATE PEM block of certBlockBytes.
+func ParseCertificate(certBlockBytes []byte) (*x509.Certificate, error) {
+	certBlock, _ := pem.Decode(certBlockBytes)
+	if certBlock == nil {
+		return nil, fmt.Errorf("no certificate PEM block found")
+	}
+	return x509.ParseCertificate(certBlock.Bytes)
+}
+
+// EncodeCSR wraps a DER certificate request in a CERTIFICATE REQUEST PEM
+// block.
+func EncodeCSR(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// ParseCSR parses the first PEM block of csrBlockBytes as a certificate
+// request and checks its signature.
+func ParseCSR(csrBlockBytes []byte) (*x509.CertificateRequest, error) {
+	csrBlock, _ := pem.Decode(csrBlockBytes)
+	if csrBlock == nil {
+		return nil, fmt.Errorf("no certificate request PEM block found")
+	}
+	csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("check csr signature failed, error %v", err)
+	}
+	return csr, nil
+}
+
+// CSROptions names the subject of a certificate request.
+type CSROptions struct {
+	Subject pkix.Name
+	SANs
+}
+
+// CreateCSR returns a PEM certificate request for privateKey.
+func CreateCSR(random io.Reader, privateKey crypto.Signer, opts CSROptions) ([]byte, error) {
+	certRequest := &x509.CertificateRequest{
+		Subject:            opts.Subject,
+		DNSNames:           opts.DNSNames,
+		IPAddresses:        opts.IPAddresses,
+		URIs:               opts.URIs,
+		EmailAddresses:     opts.EmailAddresses,
+		SignatureAlgorithm: SignatureAlgorithm(privateKey),
+	}
+	der, err := x509.CreateCertificateRequest(randReader(random), certRequest, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate request failed, error %v", err)
+	}
+	return EncodeCSR(der), nil
+}
+
+// WriteFile writes content readable by the owner only, creating the parent
+// directory when it is missing.
+func WriteFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create directory of %q failed, error %v", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("create or open file %q failed, error %v", path, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("write file %q failed, error %v", path, err)
+	}
+	return f.Close()
+}
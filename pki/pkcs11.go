@@ -0,0 +1,245 @@
+//go:build cgo
+
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs11Signer signs with a private key that never leaves the token.
+// PKCS#11 sessions are not safe for concurrent use, mu serializes them.
+type pkcs11Signer struct {
+	mu        sync.Mutex
+	ctx       *pkcs11.Ctx
+	session   pkcs11.SessionHandle
+	key       pkcs11.ObjectHandle
+	publicKey crypto.PublicKey
+}
+
+// OpenPKCS11Signer loads the module of config, logs in to its token and
+// finds the private key and its public key object.
+func OpenPKCS11Signer(config PKCS11Config) (KeySigner, error) {
+	ctx := pkcs11.New(config.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("load pkcs11 module %q failed", config.Module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("initialize pkcs11 module %q failed, error %v", config.Module, err)
+	}
+	s := &pkcs11Signer{ctx: ctx}
+	if err := s.open(config); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *pkcs11Signer) open(config PKCS11Config) error {
+	slot, err := findSlot(s.ctx, config)
+	if err != nil {
+		return err
+	}
+	if s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION); err != nil {
+		return fmt.Errorf("open pkcs11 session failed, error %v", err)
+	}
+	if err := s.ctx.Login(s.session, pkcs11.CKU_USER, config.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		return fmt.Errorf("login to pkcs11 token failed, error %v", err)
+	}
+	if s.key, err = s.findObject(pkcs11.CKO_PRIVATE_KEY, config); err != nil {
+		return err
+	}
+	publicKey, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, config)
+	if err != nil {
+		return err
+	}
+	s.publicKey, err = s.readPublicKey(publicKey)
+	return err
+}
+
+func findSlot(ctx *pkcs11.Ctx, config PKCS11Config) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("list pkcs11 slots failed, error %v", err)
+	}
+	for _, slot := range slots {
+		if config.Token == "" {
+			if config.SlotID == nil || *config.SlotID == slot {
+				return slot, nil
+			}
+			continue
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err == nil && info.Label == config.Token {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11 token %q not found", config.Token)
+}
+
+func (s *pkcs11Signer) findObject(class uint, config PKCS11Config) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if config.Label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, config.Label))
+	}
+	if len(config.ID) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, config.ID))
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, fmt.Errorf("find pkcs11 object failed, error %v", err)
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 2)
+	s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return 0, fmt.Errorf("find pkcs11 object failed, error %v", err)
+	}
+	kind := "private"
+	if class == pkcs11.CKO_PUBLIC_KEY {
+		kind = "public"
+	}
+	switch len(objects) {
+	case 0:
+		return 0, fmt.Errorf("pkcs11 %s key %q (id %x) not found", kind, config.Label, config.ID)
+	case 1:
+		return objects[0], nil
+	}
+	return 0, fmt.Errorf("pkcs11 %s key %q (id %x) is ambiguous, give both object and id", kind, config.Label, config.ID)
+}
+
+var (
+	oidCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+func (s *pkcs11Signer) readPublicKey(object pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attributes, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("read pkcs11 key type failed, error %v", err)
+	}
+	// CK_ULONG values are native endian, compare them encoded the same way
+	keyType := attributes[0].Value
+	switch {
+	case bytes.Equal(keyType, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA).Value):
+		attributes, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read pkcs11 rsa public key failed, error %v", err)
+		}
+		e := new(big.Int).SetBytes(attributes[1].Value)
+		if !e.IsInt64() {
+			return nil, errors.New("pkcs11 rsa public exponent is too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(attributes[0].Value), E: int(e.Int64())}, nil
+	case bytes.Equal(keyType, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC).Value):
+		attributes, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read pkcs11 ec public key failed, error %v", err)
+		}
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(attributes[0].Value, &oid); err != nil {
+			return nil, fmt.Errorf("parse pkcs11 ec params failed, error %v", err)
+		}
+		var curve elliptic.Curve
+		switch {
+		case oid.Equal(oidCurveP256):
+			curve = elliptic.P256()
+		case oid.Equal(oidCurveP384):
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported pkcs11 ec curve %v", oid)
+		}
+		// the point is a DER OCTET STRING, some modules return it bare
+		point := attributes[1].Value
+		var wrapped []byte
+		if rest, err := asn1.Unmarshal(point, &wrapped); err == nil && len(rest) == 0 {
+			point = wrapped
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("pkcs11 ec point is invalid")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported pkcs11 key type %x", keyType)
+	}
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// digestInfoPrefixes are the DER DigestInfo headers CKM_RSA_PKCS expects
+// in front of the digest, as in crypto/rsa.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Sign signs digest on the token, RSA PKCS#1 v1.5 or ECDSA with the DER
+// signature crypto.Signer callers expect.
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism uint
+	data := digest
+	switch s.publicKey.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("pkcs11 signer does not support rsa pss")
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("pkcs11 signer does not support hash %v", opts.HashFunc())
+		}
+		mechanism, data = pkcs11.CKM_RSA_PKCS, append(bytes.Clone(prefix), digest...)
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.CKM_ECDSA
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, s.key); err != nil {
+		return nil, fmt.Errorf("pkcs11 sign failed, error %v", err)
+	}
+	signature, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11 sign failed, error %v", err)
+	}
+	if mechanism == pkcs11.CKM_ECDSA {
+		// CKM_ECDSA returns r and s concatenated
+		half := len(signature) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(signature[:half]), new(big.Int).SetBytes(signature[half:])})
+	}
+	return signature, nil
+}
+
+func (s *pkcs11Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return nil
+	}
+	if s.session != 0 {
+		s.ctx.Logout(s.session)
+		s.ctx.CloseSession(s.session)
+	}
+	err := s.ctx.Finalize()
+	s.ctx.Destroy()
+	s.ctx = nil
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package pki
+
+import "errors"
+
+// OpenPKCS11Signer needs cgo to load the module.
+func OpenPKCS11Signer(config PKCS11Config) (KeySigner, error) {
+	return nil, errors.New("pkcs11 key backend needs a cgo build")
+}
@@ -0,0 +1,137 @@
+//go:build cgo
+
+package pki
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+// softHSMModule finds the SoftHSM module, SOFTHSM2_MODULE or a distribution
+// path.
+func softHSMModule() string {
+	for _, path := range []string{
+		os.Getenv("SOFTHSM2_MODULE"),
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+		"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+	} {
+		if path != "" && fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// initSoftHSMToken creates a token labelled DevCA with user PIN 1234 in a
+// fresh token directory and generates an EC and an RSA key pair on it.
+func initSoftHSMToken(t *testing.T, module string) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "softhsm2.conf")
+	content := fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\nlog.level = ERROR\n", dir)
+	if err := os.WriteFile(conf, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		t.Fatalf("load %s failed", module)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Finalize()
+	slots, err := ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("no softhsm slot, error %v", err)
+	}
+	if err := ctx.InitToken(slots[0], "so-pin", "DevCA"); err != nil {
+		t.Fatal(err)
+	}
+	// softhsm moves an initialized token to a new slot
+	slot, err := findSlot(ctx, PKCS11Config{Token: "DevCA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.CloseSession(session)
+	if err := ctx.Login(session, pkcs11.CKU_SO, "so-pin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.InitPIN(session, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Logout(session)
+	if err := ctx.Login(session, pkcs11.CKU_USER, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Logout(session)
+
+	generate := func(label string, mechanism uint, public ...*pkcs11.Attribute) {
+		common := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, []byte(label)),
+		}
+		public = append(append(public, common...), pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true))
+		private := append(common, pkcs11.NewAttribute(pkcs11.CKA_SIGN, true), pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true), pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true))
+		if _, _, err := ctx.GenerateKeyPair(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, public, private); err != nil {
+			t.Fatalf("generate %s key failed, error %v", label, err)
+		}
+	}
+	p256, err := asn1.Marshal(oidCurveP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generate("ec", pkcs11.CKM_EC_KEY_PAIR_GEN, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, p256))
+	generate("rsa", pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048), pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	module := softHSMModule()
+	if module == "" {
+		t.Skip("softhsm not installed, set SOFTHSM2_MODULE to run")
+	}
+	initSoftHSMToken(t, module)
+
+	for _, label := range []string{"ec", "rsa"} {
+		uri := fmt.Sprintf("pkcs11:token=DevCA;object=%s?module-path=%s", label, module)
+		if _, err := OpenSigner("", uri, "4321"); err == nil {
+			t.Errorf("%s: expected a wrong pin to be rejected", label)
+		}
+		signer, err := OpenSigner("", uri, "1234")
+		if err != nil {
+			t.Fatalf("%s: %v", label, err)
+		}
+		caBlock, err := SignCACert(nil, signer, "HSM CA", CertOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", label, err)
+		}
+		caCert, err := ParseCertificate(caBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := caCert.CheckSignatureFrom(caCert); err != nil {
+			t.Errorf("%s: ca signature invalid, error %v", label, err)
+		}
+		if err := signer.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
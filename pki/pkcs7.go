@@ -0,0 +1,64 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is the [0] EXPLICIT wrapper, its Bytes the content itself.
+	Content asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// signedData is the RFC 5652 SignedData, here only ever without content
+// and signers, the certs-only form EST uses to ship certificates.
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	SignerInfos      []asn1.RawValue `asn1:"set"`
+}
+
+// EncodeCertsOnly returns a degenerate PKCS#7 SignedData, DER, holding the
+// DER certificates in ders, as openssl crl2pkcs7 -nocrl writes.
+func EncodeCertsOnly(ders ...[]byte) ([]byte, error) {
+	content, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(ders, nil)},
+		SignerInfos:      []asn1.RawValue{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode pkcs7 failed, error %v", err)
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}})
+}
+
+// ParseCertsOnly returns the certificates of a DER PKCS#7 SignedData.
+func ParseCertsOnly(der []byte) ([]*x509.Certificate, error) {
+	var info contentInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("parse pkcs7 failed, error %v", err)
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("parse pkcs7 failed, trailing data")
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("pkcs7 content type %v is not signed data", info.ContentType)
+	}
+	var data signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &data); err != nil {
+		return nil, fmt.Errorf("parse pkcs7 signed data failed, error %v", err)
+	}
+	return x509.ParseCertificates(data.Certificates.Bytes)
+}
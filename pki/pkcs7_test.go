@@ -0,0 +1,41 @@
+package pki
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCertsOnly(t *testing.T) {
+	key, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := SignCACert(nil, key, "Root", CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := CreateCSR(nil, key, CSROptions{SANs: SANs{DNSNames: []string{"leaf.dev.local"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := SignServerCert(nil, csr, ca, key, CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := ParseCertificate(ca)
+	leafCert, _ := ParseCertificate(leaf)
+	der, err := EncodeCertsOnly(leafCert.Raw, caCert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ParseCertsOnly(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !bytes.Equal(certs[0].Raw, leafCert.Raw) || !bytes.Equal(certs[1].Raw, caCert.Raw) {
+		t.Errorf("parsed %d certificates, want the leaf and the ca in order", len(certs))
+	}
+	if _, err := ParseCertsOnly(leafCert.Raw); err == nil {
+		t.Error("expected an error parsing a certificate as pkcs7")
+	}
+}
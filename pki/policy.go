@@ -0,0 +1,264 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"example.com/lx/beego/dev/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy says which CSRs a CA signs. Lists are allow lists: a CSR asking
+// for DNS names, IP addresses or URIs needs a matching suffix, range,
+// trust domain or pattern, an empty list allows none.
+type Policy struct {
+	// DNSSuffixes allow a domain and its subdomains, dev.local matches
+	// dev.local, kafka.dev.local and *.dev.local.
+	DNSSuffixes []string `json:"dnsSuffixes" yaml:"dnsSuffixes"`
+	// IPRanges are CIDRs such as 10.0.0.0/8 or 127.0.0.1/32.
+	IPRanges []string `json:"ipRanges" yaml:"ipRanges"`
+	// TrustDomains allow spiffe:// URI SANs in these SPIFFE trust domains,
+	// dev.local allows spiffe://dev.local/ns/prod/sa/api.
+	TrustDomains []string `json:"trustDomains" yaml:"trustDomains"`
+	// URIPatterns are regular expressions other URI SANs must match whole.
+	URIPatterns []string `json:"uriPatterns" yaml:"uriPatterns"`
+	// Subject maps short field names (CN, O, OU, C, ST, L) to regular
+	// expressions the whole value must match. When set, fields not listed
+	// are rejected and listed fields are required unless the pattern
+	// matches the empty string.
+	Subject map[string]string `json:"subject" yaml:"subject"`
+	// KeyTypes allows rsa, ecdsa and ed25519, all of them when empty.
+	KeyTypes     []string `json:"keyTypes" yaml:"keyTypes"`
+	MinRSABits   int      `json:"minRSABits" yaml:"minRSABits"`
+	MinECDSABits int      `json:"minECDSABits" yaml:"minECDSABits"`
+	// MaxValidityDays caps the validity, requests for more are rejected.
+	MaxValidityDays int `json:"maxValidityDays" yaml:"maxValidityDays"`
+	// Profiles the CSR may be signed with, the DefaultProfiles when empty.
+	Profiles []string `json:"profiles" yaml:"profiles"`
+}
+
+// PolicyPath is where a CA looks for its policy when none is named.
+func PolicyPath(caCertPath string) string {
+	return utils.CAPrefix(caCertPath) + ".policy.yaml"
+}
+
+// LoadPolicy reads a YAML policy, or JSON for a .json file.
+func LoadPolicy(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy %q failed, error %v", path, err)
+	}
+	policy := &Policy{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(content, policy)
+	} else {
+		err = yaml.Unmarshal(content, policy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse policy %q failed, error %v", path, err)
+	}
+	return policy, nil
+}
+
+func (p *Policy) dnsAllowed(name string) bool {
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	for _, suffix := range p.DNSSuffixes {
+		suffix = strings.Trim(strings.ToLower(suffix), ".")
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) ipAllowed(ip net.IP) (bool, error) {
+	for _, cidr := range p.IPRanges {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return false, fmt.Errorf("policy has invalid ip range %q", cidr)
+		}
+		if ipNet.Contains(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *Policy) uriViolation(uri *url.URL) string {
+	if uri.Scheme == "spiffe" {
+		id, err := ParseSPIFFEID(uri.String())
+		if err != nil {
+			return err.Error()
+		}
+		for _, trustDomain := range p.TrustDomains {
+			if InTrustDomain(id, strings.ToLower(trustDomain)) {
+				return ""
+			}
+		}
+	}
+	for _, pattern := range p.URIPatterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Sprintf("policy has invalid uri pattern %q", pattern)
+		}
+		if re.MatchString(uri.String()) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("uri %q is outside the allowed trust domains %v and patterns %v", uri, p.TrustDomains, p.URIPatterns)
+}
+
+func (p *Policy) keyViolation(publicKey any) string {
+	keyType, minBits, bits := "", 0, 0
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		keyType, minBits, bits = "rsa", p.MinRSABits, key.N.BitLen()
+		if minBits == 0 {
+			minBits = 2048
+		}
+	case *ecdsa.PublicKey:
+		keyType, minBits, bits = "ecdsa", p.MinECDSABits, key.Curve.Params().BitSize
+		if minBits == 0 {
+			minBits = 256
+		}
+	case ed25519.PublicKey:
+		keyType = "ed25519"
+	default:
+		return fmt.Sprintf("unsupported public key type %T", publicKey)
+	}
+	if len(p.KeyTypes) > 0 && !containsFold(p.KeyTypes, keyType) {
+		return fmt.Sprintf("key type %s is not allowed, want one of %v", keyType, p.KeyTypes)
+	}
+	if bits < minBits {
+		return fmt.Sprintf("%s key has %d bits, policy requires at least %d", keyType, bits, minBits)
+	}
+	return ""
+}
+
+func (p *Policy) subjectViolations(csr *x509.CertificateRequest) []string {
+	if len(p.Subject) == 0 {
+		return nil
+	}
+	values := map[string][]string{
+		"CN": nil, "O": csr.Subject.Organization, "OU": csr.Subject.OrganizationalUnit,
+		"C": csr.Subject.Country, "ST": csr.Subject.Province, "L": csr.Subject.Locality,
+	}
+	subjectShortNames := map[string]string{"2.5.4.3": "CN", "2.5.4.10": "O", "2.5.4.11": "OU", "2.5.4.6": "C", "2.5.4.8": "ST", "2.5.4.7": "L"}
+	if csr.Subject.CommonName != "" {
+		values["CN"] = []string{csr.Subject.CommonName}
+	}
+	var violations []string
+	for _, field := range []string{"CN", "O", "OU", "C", "ST", "L"} {
+		fieldValues := values[field]
+		pattern, ok := p.Subject[field]
+		if !ok {
+			if len(fieldValues) > 0 {
+				violations = append(violations, fmt.Sprintf("subject field %s is not allowed", field))
+			}
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("policy has invalid pattern %q for %s", pattern, field))
+			continue
+		}
+		if len(fieldValues) == 0 && !re.MatchString("") {
+			violations = append(violations, fmt.Sprintf("subject field %s is required", field))
+		}
+		for _, value := range fieldValues {
+			if !re.MatchString(value) {
+				violations = append(violations, fmt.Sprintf("subject %s=%q does not match %q", field, value, pattern))
+			}
+		}
+	}
+	for field := range p.Subject {
+		if _, ok := values[field]; !ok {
+			violations = append(violations, fmt.Sprintf("policy has unknown subject field %q", field))
+		}
+	}
+	for _, attribute := range csr.Subject.Names {
+		name, ok := subjectShortNames[attribute.Type.String()]
+		if _, checked := values[name]; !ok || !checked {
+			violations = append(violations, fmt.Sprintf("subject attribute %s is not allowed", attribute.Type))
+		}
+	}
+	return violations
+}
+
+// Check validates csr for issuance with profile and validityDays, 0 meaning
+// the profile default. It returns the validity to issue with, clamped to
+// MaxValidityDays when none was asked for, or every reason for rejection.
+func (p *Policy) Check(csr *x509.CertificateRequest, profile string, validityDays int) (int, error) {
+	var violations []string
+	if err := csr.CheckSignature(); err != nil {
+		violations = append(violations, fmt.Sprintf("csr signature is invalid, %v", err))
+	}
+	allowedProfiles := p.Profiles
+	if len(allowedProfiles) == 0 {
+		allowedProfiles = DefaultProfiles
+	}
+	profile = profileName(profile)
+	profileSpec, err := LookupProfile(profile)
+	if err != nil {
+		violations = append(violations, err.Error())
+	} else if !containsFold(allowedProfiles, profile) {
+		violations = append(violations, fmt.Sprintf("profile %s is not allowed, want one of %v", profile, allowedProfiles))
+	}
+	if violation := p.keyViolation(csr.PublicKey); violation != "" {
+		violations = append(violations, violation)
+	}
+	violations = append(violations, p.subjectViolations(csr)...)
+	for _, name := range csr.DNSNames {
+		if !p.dnsAllowed(name) {
+			violations = append(violations, fmt.Sprintf("dns name %q is outside the allowed suffixes %v", name, p.DNSSuffixes))
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		allowed, err := p.ipAllowed(ip)
+		if err != nil {
+			violations = append(violations, err.Error())
+		} else if !allowed {
+			violations = append(violations, fmt.Sprintf("ip address %s is outside the allowed ranges %v", ip, p.IPRanges))
+		}
+	}
+	for _, uri := range csr.URIs {
+		if violation := p.uriViolation(uri); violation != "" {
+			violations = append(violations, violation)
+		}
+	}
+	if len(csr.EmailAddresses) > 0 {
+		violations = append(violations, "email subject alternative names are not allowed")
+	}
+	if validityDays == 0 {
+		validityDays = profileSpec.ValidityDays
+		if p.MaxValidityDays > 0 && validityDays > p.MaxValidityDays {
+			validityDays = p.MaxValidityDays
+		}
+	} else if p.MaxValidityDays > 0 && validityDays > p.MaxValidityDays {
+		violations = append(violations, fmt.Sprintf("validity of %d days exceeds the maximum of %d", validityDays, p.MaxValidityDays))
+	}
+	if len(violations) > 0 {
+		return 0, errors.New(strings.Join(violations, "; "))
+	}
+	return validityDays, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
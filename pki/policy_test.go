@@ -0,0 +1,107 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	key, err := GenerateKey(rand.Reader, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCSR := func(subject pkix.Name, dnsNames []string, ips []net.IP) *x509.CertificateRequest {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject, DNSNames: dnsNames, IPAddresses: ips}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return csr
+	}
+	policy := &Policy{
+		DNSSuffixes:     []string{"dev.local"},
+		IPRanges:        []string{"10.0.0.0/8"},
+		Subject:         map[string]string{"CN": "[a-z0-9.-]+", "O": "devCompany", "OU": ".*"},
+		MinECDSABits:    256,
+		MaxValidityDays: 90,
+	}
+
+	good := newCSR(pkix.Name{CommonName: "kafka.dev.local", Organization: []string{"devCompany"}}, []string{"kafka.dev.local", "*.dev.local"}, []net.IP{net.ParseIP("10.1.2.3")})
+	days, err := policy.Check(good, "tls-server", 0)
+	if err != nil {
+		t.Fatalf("good csr rejected, error %v", err)
+	}
+	if days != 90 {
+		t.Errorf("validity is %d days, want the policy maximum 90", days)
+	}
+
+	bad := newCSR(pkix.Name{CommonName: "Kafka", Organization: []string{"other"}, Locality: []string{"x"}}, []string{"kafka.example.com", "notdev.local"}, []net.IP{net.ParseIP("192.168.0.1")})
+	_, err = policy.Check(bad, "sub-ca", 365)
+	if err == nil {
+		t.Fatal("expected bad csr to be rejected")
+	}
+	for _, reason := range []string{
+		"profile sub-ca is not allowed",
+		`subject CN="Kafka"`,
+		`subject O="other"`,
+		"subject field L is not allowed",
+		`dns name "kafka.example.com"`,
+		`dns name "notdev.local"`,
+		"ip address 192.168.0.1",
+		"validity of 365 days exceeds the maximum of 90",
+	} {
+		if !strings.Contains(err.Error(), reason) {
+			t.Errorf("rejection %q does not mention %q", err, reason)
+		}
+	}
+
+	newURICSR := func(uris ...string) *x509.CertificateRequest {
+		template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "api", Organization: []string{"devCompany"}}}
+		for _, uri := range uris {
+			parsed, err := url.Parse(uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template.URIs = append(template.URIs, parsed)
+		}
+		der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return csr
+	}
+	svid := newURICSR("spiffe://dev.local/ns/prod/sa/api")
+	if _, err := policy.Check(svid, "tls-server", 0); err == nil || !strings.Contains(err.Error(), "outside the allowed trust domains") {
+		t.Errorf("expected a uri outside the policy to be rejected, error %v", err)
+	}
+	policy.TrustDomains = []string{"dev.local"}
+	policy.URIPatterns = []string{`https://api\.dev\.local/.*`}
+	if _, err := policy.Check(svid, "tls-server", 0); err != nil {
+		t.Errorf("svid of the trust domain rejected, error %v", err)
+	}
+	if _, err := policy.Check(newURICSR("https://api.dev.local/v1"), "tls-server", 0); err != nil {
+		t.Errorf("uri matching a pattern rejected, error %v", err)
+	}
+	for _, uri := range []string{"spiffe://other.local/api", "spiffe://dev.local/../api", "https://api.example.com/v1"} {
+		if _, err := policy.Check(newURICSR(uri), "tls-server", 0); err == nil {
+			t.Errorf("expected uri %s to be rejected", uri)
+		}
+	}
+
+	policy.MinECDSABits = 384
+	if _, err := policy.Check(good, "tls-server", 30); err == nil || !strings.Contains(err.Error(), "at least 384") {
+		t.Errorf("expected the p256 key to be rejected, error %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+)
+
+// Profile is a named issuance template: what a certificate may be used for,
+// how long it is valid by default and whether it is a CA.
+type Profile struct {
+	KeyUsage x509.KeyUsage
+	// KeyEncipherment adds that usage for RSA keys, where TLS key transport
+	// still needs it.
+	KeyEncipherment bool
+	ExtKeyUsage     []x509.ExtKeyUsage
+	ValidityDays    int
+	IsCA            bool
+	// MaxPathLen is the default path length of CA profiles, -1 unlimited.
+	MaxPathLen  int
+	OCSPNoCheck bool
+}
+
+// DefaultProfile is used for leaves without a profile: Kafka brokers and
+// the bootstrap server present the same certificate as server and client,
+// so tls-peer carries both serverAuth and clientAuth, as the leaves of the
+// generator always did.
+const DefaultProfile = "tls-peer"
+
+// Profiles holds the built-in profiles by name.
+var Profiles = map[string]Profile{
+	"root-ca": {
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ValidityDays: 365,
+		IsCA:         true,
+		MaxPathLen:   -1,
+	},
+	"sub-ca": {
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ValidityDays: 1825,
+		IsCA:         true,
+		MaxPathLen:   0,
+	},
+	"tls-server": {
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		KeyEncipherment: true,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ValidityDays:    365,
+	},
+	"tls-client": {
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		KeyEncipherment: true,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		ValidityDays:    365,
+	},
+	"tls-peer": {
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		KeyEncipherment: true,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ValidityDays:    365,
+	},
+	"code-signing": {
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ValidityDays: 365,
+	},
+	"email": {
+		KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		KeyEncipherment: true,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		ValidityDays:    365,
+	},
+	"ocsp-signer": {
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ValidityDays: 90,
+		OCSPNoCheck:  true,
+	},
+}
+
+// ProfileAliases keeps the profile names of older topology files working.
+var ProfileAliases = map[string]string{
+	"server": "tls-server",
+	"client": "tls-client",
+}
+
+// ProfileNames lists the profile names in sorted order.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profileName resolves name, the default profile when empty, and aliases.
+func profileName(name string) string {
+	if name == "" {
+		name = DefaultProfile
+	}
+	if alias, ok := ProfileAliases[name]; ok {
+		name = alias
+	}
+	return name
+}
+
+// LookupProfile resolves name, the default profile when empty, and aliases.
+func LookupProfile(name string) (Profile, error) {
+	name = profileName(name)
+	profile, ok := Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q, want one of %v", name, ProfileNames())
+	}
+	return profile, nil
+}
+
+// oidOCSPNoCheck marks a delegated OCSP responder certificate as trusted
+// for its lifetime, clients do not check its own revocation status.
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// Apply sets usages and basic constraints of template for publicKey. The
+// validity comes from opts, or the profile default when opts has none,
+// starting at template.NotBefore.
+func (p Profile) Apply(template *x509.Certificate, publicKey crypto.PublicKey, opts CertOptions) {
+	if opts.ValidityDays == 0 {
+		opts.ValidityDays = p.ValidityDays
+	}
+	template.NotAfter = opts.notAfter(template.NotBefore)
+	template.KeyUsage = p.KeyUsage
+	if p.KeyEncipherment {
+		template.KeyUsage |= keyEnciphermentUsage(publicKey)
+	}
+	template.ExtKeyUsage = p.ExtKeyUsage
+	template.BasicConstraintsValid = true
+	template.IsCA = p.IsCA
+	if p.IsCA {
+		opts.applyPathLen(template, p.MaxPathLen)
+	}
+	if p.OCSPNoCheck {
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oidOCSPNoCheck, Value: asn1.NullBytes})
+	}
+}
+
+// ProfileOf names the leaf profile whose extended key usages match cert,
+// the default profile when none does.
+func ProfileOf(cert *x509.Certificate) string {
+	for _, name := range ProfileNames() {
+		profile := Profiles[name]
+		if profile.IsCA || len(profile.ExtKeyUsage) != len(cert.ExtKeyUsage) {
+			continue
+		}
+		matched := true
+		for i, usage := range profile.ExtKeyUsage {
+			if cert.ExtKeyUsage[i] != usage {
+				matched = false
+			}
+		}
+		if matched {
+			return name
+		}
+	}
+	return DefaultProfile
+}
@@ -0,0 +1,75 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	caKey, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertBlock, err := SignCACert(nil, caKey, "TestRoot", CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := ParseCertificate(caCertBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caCert.ExtKeyUsage) != 0 || caCert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+		t.Errorf("root has ext key usage %v and key usage %b", caCert.ExtKeyUsage, caCert.KeyUsage)
+	}
+	if days := caCert.NotAfter.Sub(caCert.NotBefore).Hours() / 24; days != 365 {
+		t.Errorf("root is valid %v days, want the 365 of the generator", days)
+	}
+	leafKey, err := GenerateKey(nil, "rsa2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := CreateCSR(nil, leafKey, CSROptions{Subject: pkix.Name{CommonName: "TestLeaf"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		profile string
+		usage   x509.ExtKeyUsage
+		isCA    bool
+	}{
+		{"tls-server", x509.ExtKeyUsageServerAuth, false},
+		{"client", x509.ExtKeyUsageClientAuth, false},
+		{"code-signing", x509.ExtKeyUsageCodeSigning, false},
+		{"email", x509.ExtKeyUsageEmailProtection, false},
+		{"ocsp-signer", x509.ExtKeyUsageOCSPSigning, false},
+		{"sub-ca", 0, true},
+	} {
+		certBlock, err := SignServerCert(nil, csr, caCertBlock, caKey, CertOptions{Profile: c.profile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := ParseCertificate(certBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.IsCA != c.isCA {
+			t.Errorf("%s: IsCA is %v", c.profile, cert.IsCA)
+		}
+		if c.isCA {
+			if cert.KeyUsage&x509.KeyUsageCertSign == 0 || len(cert.ExtKeyUsage) != 0 {
+				t.Errorf("%s: key usage %b, ext key usage %v", c.profile, cert.KeyUsage, cert.ExtKeyUsage)
+			}
+			continue
+		}
+		if cert.KeyUsage&x509.KeyUsageCertSign != 0 {
+			t.Errorf("%s: leaf can sign certificates", c.profile)
+		}
+		if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != c.usage {
+			t.Errorf("%s: ext key usage is %v, want %v", c.profile, cert.ExtKeyUsage, c.usage)
+		}
+	}
+	if _, err := LookupProfile("unknown"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
@@ -0,0 +1,101 @@
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Shamir secret sharing over GF(2^8) with the AES polynomial, each byte of
+// the secret is the constant term of its own random polynomial of degree
+// threshold-1. Share i holds the polynomials evaluated at x = i.
+
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// multiply by the generator 3, x*2 reduced by 0x11b, plus x
+		double := x << 1
+		if x&0x80 != 0 {
+			double ^= 0x1b
+		}
+		x ^= double
+	}
+	return exp, log
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// splitSecret returns total shares of secret, any threshold of which
+// recover it. Share i-1 is the evaluation at x = i.
+func splitSecret(random io.Reader, secret []byte, threshold, total int) ([][]byte, error) {
+	if threshold < 2 || threshold > total || total > 255 {
+		return nil, fmt.Errorf("cannot split into %d-of-%d shares, want 2 <= threshold <= shares <= 255", threshold, total)
+	}
+	coefficients := make([]byte, len(secret)*(threshold-1))
+	if _, err := io.ReadFull(randReader(random), coefficients); err != nil {
+		return nil, fmt.Errorf("read random coefficients failed, error %v", err)
+	}
+	shares := make([][]byte, total)
+	for i := range shares {
+		x := byte(i + 1)
+		share := make([]byte, len(secret))
+		for k, constant := range secret {
+			// Horner, highest coefficient first
+			y := byte(0)
+			for c := threshold - 2; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[k*(threshold-1)+c]
+			}
+			share[k] = gfMul(y, x) ^ constant
+		}
+		shares[i] = share
+	}
+	return shares, nil
+}
+
+// combineSecret interpolates the polynomials at 0 from the shares at
+// distinct non zero xs.
+func combineSecret(xs []byte, shares [][]byte) ([]byte, error) {
+	if len(xs) != len(shares) || len(shares) == 0 {
+		return nil, errors.New("no shares to combine")
+	}
+	for i := range xs {
+		if xs[i] == 0 || len(shares[i]) != len(shares[0]) {
+			return nil, errors.New("shares do not belong together")
+		}
+		for j := 0; j < i; j++ {
+			if xs[i] == xs[j] {
+				return nil, fmt.Errorf("share %d is given twice", xs[i])
+			}
+		}
+	}
+	secret := make([]byte, len(shares[0]))
+	for i, xi := range xs {
+		// Lagrange basis at 0, subtraction is xor in GF(2^8)
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xj, xj^xi))
+			}
+		}
+		for k, y := range shares[i] {
+			secret[k] ^= gfMul(y, basis)
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,306 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+)
+
+// CertOptions carries the per-certificate settings a topology file can
+// override. The zero value keeps the validity and usages of the profile.
+type CertOptions struct {
+	ValidityDays          int
+	Profile               string
+	CRLDistributionPoints []string
+	OCSPServers           []string
+	// MaxPathLen limits the CAs allowed below a CA certificate, nil keeps
+	// the default: unlimited for roots, 0 for intermediates.
+	MaxPathLen *int
+	// NotBefore defaults to now. NotAfter wins over Validity, which wins
+	// over ValidityDays.
+	NotBefore time.Time
+	NotAfter  time.Time
+	Validity  time.Duration
+	// Subject and SANs replace the names SignCACert and SignSubCACert
+	// derive from the common name and the issuer, leaves take theirs from
+	// the CSR.
+	Subject *pkix.Name
+	SANs    *SANs
+}
+
+// applyPathLen sets the path length constraint of a CA template, def is
+// used when the options do not set one, -1 meaning unlimited.
+func (o CertOptions) applyPathLen(template *x509.Certificate, def int) {
+	pathLen := def
+	if o.MaxPathLen != nil {
+		pathLen = *o.MaxPathLen
+	}
+	template.MaxPathLen = pathLen
+	template.MaxPathLenZero = pathLen == 0
+}
+
+func (o CertOptions) notBefore() time.Time {
+	if !o.NotBefore.IsZero() {
+		return o.NotBefore
+	}
+	return time.Now()
+}
+
+func (o CertOptions) notAfter(before time.Time) time.Time {
+	if !o.NotAfter.IsZero() {
+		return o.NotAfter
+	}
+	if o.Validity > 0 {
+		return before.Add(o.Validity)
+	}
+	if o.ValidityDays > 0 {
+		return before.AddDate(0, 0, o.ValidityDays)
+	}
+	return before.AddDate(1, 0, 0)
+}
+
+// createCertificate signs template after checking its validity period.
+func createCertificate(random io.Reader, template, parent *x509.Certificate, publicKey crypto.PublicKey, signer crypto.Signer) ([]byte, error) {
+	if !template.NotAfter.After(template.NotBefore) {
+		return nil, fmt.Errorf("not after %s is not later than not before %s", template.NotAfter.Format(time.RFC3339), template.NotBefore.Format(time.RFC3339))
+	}
+	return x509.CreateCertificate(randReader(random), template, parent, publicKey, signer)
+}
+
+// applyNames replaces the subject and SANs of template with those of the
+// options, commonName filling in a subject without one.
+func (o CertOptions) applyNames(template *x509.Certificate, commonName string) {
+	if o.Subject != nil {
+		template.Subject = *o.Subject
+		if template.Subject.CommonName == "" {
+			template.Subject.CommonName = commonName
+		}
+	}
+	if o.SANs != nil {
+		template.DNSNames = o.SANs.DNSNames
+		template.IPAddresses = o.SANs.IPAddresses
+		template.URIs = o.SANs.URIs
+		template.EmailAddresses = o.SANs.EmailAddresses
+	}
+}
+
+// NewSerialNumber returns a random 128 bit serial number.
+func NewSerialNumber(random io.Reader) (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(randReader(random), serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("generate serial number failed, error %v", err)
+	}
+	return serialNumber, nil
+}
+
+// SignCACert self-signs a root CA certificate for privateKey with the
+// root-ca profile. random defaults to crypto/rand here and in the other
+// Sign functions.
+func SignCACert(random io.Reader, privateKey crypto.Signer, serviceName string, opts CertOptions) ([]byte, error) {
+	serialNumber, err := NewSerialNumber(random)
+	if err != nil {
+		return nil, err
+	}
+	before := opts.notBefore()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Country:            []string{"CN"},
+			Province:           []string{"BeiJing"},
+			Organization:       []string{"devCompany"},
+			OrganizationalUnit: []string{"devTeam"},
+			CommonName:         serviceName,
+		},
+		NotBefore:          before,
+		SignatureAlgorithm: SignatureAlgorithm(privateKey),
+		IPAddresses:        []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:           []string{serviceName},
+	}
+	opts.applyNames(&template, serviceName)
+	Profiles["root-ca"].Apply(&template, privateKey.Public(), opts)
+	if template.SubjectKeyId, err = SubjectKeyID(privateKey.Public()); err != nil {
+		return nil, fmt.Errorf("compute subject key id failed, error %v", err)
+	}
+	der, err := createCertificate(random, &template, &template, privateKey.Public(), privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("create ca certificate failed, error %v", err)
+	}
+	return EncodeCertificate(der), nil
+}
+
+// SignCrossCert re-issues the CA certificate in certBlockBytes under the CA
+// in caCertBlockBytes, privateKey being the key of that CA.
+func SignCrossCert(random io.Reader, privateKey crypto.Signer, caCertBlockBytes, certBlockBytes []byte, opts CertOptions) ([]byte, error) {
+	cert, err := ParseCertificate(certBlockBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate failed, error %v", err)
+	}
+	caCert, err := ParseCertificate(caCertBlockBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse ca certificate failed, error %v", err)
+	}
+	serialNumber, err := NewSerialNumber(random)
+	if err != nil {
+		return nil, err
+	}
+	before := opts.notBefore()
+	after := opts.notAfter(before)
+
+	// same subject, key and key id as cert, so chains can be built through
+	// either; the issuer's key id becomes the authority key id.
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               cert.Subject,
+		NotBefore:             before,
+		NotAfter:              after,
+		BasicConstraintsValid: true,
+		IsCA:                  cert.IsCA,
+		MaxPathLen:            cert.MaxPathLen,
+		MaxPathLenZero:        cert.MaxPathLenZero,
+		KeyUsage:              cert.KeyUsage,
+		ExtKeyUsage:           cert.ExtKeyUsage,
+		SubjectKeyId:          cert.SubjectKeyId,
+		SignatureAlgorithm:    SignatureAlgorithm(privateKey),
+		IPAddresses:           cert.IPAddresses,
+		DNSNames:              cert.DNSNames,
+		URIs:                  cert.URIs,
+		EmailAddresses:        cert.EmailAddresses,
+	}
+	if cert.IsCA && opts.MaxPathLen != nil {
+		opts.applyPathLen(template, -1)
+	}
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	der, err := createCertificate(random, template, caCert, cert.PublicKey, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("create cross certificate failed, error %v", err)
+	}
+	return EncodeCertificate(der), nil
+}
+
+// SignSubCACert issues an intermediate CA certificate for publicKey under
+// the CA in caCertBlockBytes. Unless opts say otherwise it may only issue
+// leaves, MaxPathLen 0.
+func SignSubCACert(random io.Reader, publicKey crypto.PublicKey, commonName string, caCertBlockBytes []byte, caPrivateKey crypto.Signer, opts CertOptions) ([]byte, error) {
+	caCert, err := ParseCertificate(caCertBlockBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse ca certificate failed, error %v", err)
+	}
+	serialNumber, err := NewSerialNumber(random)
+	if err != nil {
+		return nil, err
+	}
+	before := opts.notBefore()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Country:            caCert.Subject.Country,
+			Province:           caCert.Subject.Province,
+			Organization:       caCert.Subject.Organization,
+			OrganizationalUnit: caCert.Subject.OrganizationalUnit,
+			CommonName:         commonName,
+		},
+		NotBefore:             before,
+		SignatureAlgorithm:    SignatureAlgorithm(caPrivateKey),
+		CRLDistributionPoints: opts.CRLDistributionPoints,
+		OCSPServer:            opts.OCSPServers,
+	}
+	opts.applyNames(template, commonName)
+	Profiles["sub-ca"].Apply(template, publicKey, opts)
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	if template.SubjectKeyId, err = SubjectKeyID(publicKey); err != nil {
+		return nil, fmt.Errorf("compute subject key id failed, error %v", err)
+	}
+	der, err := createCertificate(random, template, caCert, publicKey, caPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("create intermediate ca certificate failed, error %v", err)
+	}
+	return EncodeCertificate(der), nil
+}
+
+// SignServerCert signs a CSR with the profile named in opts, the default
+// tls-peer profile when none is set.
+func SignServerCert(random io.Reader, csrBlockBytes, caCertBlockBytes []byte, caPrivateKey crypto.Signer, opts CertOptions) ([]byte, error) {
+	csr, err := ParseCSR(csrBlockBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse csr failed, error %v", err)
+	}
+	caCert, err := ParseCertificate(caCertBlockBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse ca certificate failed, error %v", err)
+	}
+	serialNumber, err := NewSerialNumber(random)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := LookupProfile(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	before := opts.notBefore()
+	serverCert := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               csr.Subject,
+		NotBefore:             before,
+		SignatureAlgorithm:    SignatureAlgorithm(caPrivateKey),
+		IPAddresses:           csr.IPAddresses,
+		DNSNames:              csr.DNSNames,
+		URIs:                  csr.URIs,
+		EmailAddresses:        csr.EmailAddresses,
+		CRLDistributionPoints: opts.CRLDistributionPoints,
+		OCSPServer:            opts.OCSPServers,
+	}
+	profile.Apply(&serverCert, csr.PublicKey, opts)
+	if serverCert.NotAfter.After(caCert.NotAfter) {
+		serverCert.NotAfter = caCert.NotAfter
+	}
+	if serverCert.SubjectKeyId, err = SubjectKeyID(csr.PublicKey); err != nil {
+		return nil, fmt.Errorf("compute subject key id failed, error %v", err)
+	}
+	der, err := createCertificate(random, &serverCert, caCert, csr.PublicKey, caPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("create server certificate failed, error %v", err)
+	}
+	return EncodeCertificate(der), nil
+}
+
+// SignOCSPSignerCert issues the delegated OCSP signing certificate of a CA
+// for publicKey with the ocsp-signer profile, the responder uses it instead
+// of the CA key.
+func SignOCSPSignerCert(random io.Reader, publicKey crypto.PublicKey, caCertBlockBytes []byte, caPrivateKey crypto.Signer, opts CertOptions) ([]byte, error) {
+	caCert, err := ParseCertificate(caCertBlockBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse ca certificate failed, error %v", err)
+	}
+	serialNumber, err := NewSerialNumber(random)
+	if err != nil {
+		return nil, err
+	}
+	before := opts.notBefore()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization:       caCert.Subject.Organization,
+			OrganizationalUnit: caCert.Subject.OrganizationalUnit,
+			CommonName:         caCert.Subject.CommonName + " OCSP Responder",
+		},
+		NotBefore:          before,
+		SignatureAlgorithm: SignatureAlgorithm(caPrivateKey),
+	}
+	Profiles["ocsp-signer"].Apply(template, publicKey, opts)
+	der, err := createCertificate(random, template, caCert, publicKey, caPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("create ocsp signer certificate failed, error %v", err)
+	}
+	return EncodeCertificate(der), nil
+}
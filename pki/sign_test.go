@@ -0,0 +1,79 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy exhausted")
+}
+
+func TestSignCrossAndErrors(t *testing.T) {
+	key1, err := GenerateKey(nil, "ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca1, err := SignCACert(nil, key1, "Root1", CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key0, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca0, err := SignCACert(nil, key0, "Root0", CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cross, err := SignCrossCert(nil, key0, ca0, ca1, CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossCert, err := ParseCertificate(cross)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root1, _ := ParseCertificate(ca1)
+	root0, _ := ParseCertificate(ca0)
+	if !bytes.Equal(crossCert.RawSubject, root1.RawSubject) || !bytes.Equal(crossCert.SubjectKeyId, root1.SubjectKeyId) {
+		t.Errorf("cross certificate does not carry the subject and key id of the root")
+	}
+	if err := crossCert.CheckSignatureFrom(root0); err != nil {
+		t.Errorf("cross certificate not signed by root0: %v", err)
+	}
+	longCross, err := SignCrossCert(nil, key0, ca0, ca1, CertOptions{ValidityDays: 100 * 365})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if longCert, _ := ParseCertificate(longCross); !longCert.NotAfter.Equal(root0.NotAfter) {
+		t.Errorf("cross certificate expires %v, after its issuer %v", longCert.NotAfter, root0.NotAfter)
+	}
+
+	if _, err := SignCACert(failingReader{}, key0, "Root", CertOptions{}); err == nil {
+		t.Error("expected the serial number error of a failing random source")
+	}
+	csr, err := CreateCSR(nil, key0, CSROptions{Subject: pkix.Name{CommonName: "leaf"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignServerCert(nil, csr, ca0, key0, CertOptions{Profile: "unknown"}); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+	if _, err := SignServerCert(nil, []byte("not pem"), ca0, key0, CertOptions{}); err == nil {
+		t.Error("expected an error for a malformed csr")
+	}
+	leaf, err := SignServerCert(nil, csr, ca0, key0, CertOptions{Profile: "tls-server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafCert, _ := ParseCertificate(leaf)
+	if len(leafCert.ExtKeyUsage) != 1 || leafCert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("leaf ext key usage is %v", leafCert.ExtKeyUsage)
+	}
+}
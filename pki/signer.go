@@ -0,0 +1,202 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+// KeySigner is a CA key backend. It signs without handing out the key, so
+// the key may live in an encrypted file or an HSM. Close releases whatever
+// the backend holds, a session or the decrypted key.
+type KeySigner interface {
+	crypto.Signer
+	io.Closer
+}
+
+// KeyBackends lists the values accepted wherever a key backend is chosen.
+var KeyBackends = []string{"file", "encrypted-file", "pkcs11", "shares"}
+
+// OpenSigner opens the key named by key with backend. The file backends
+// read a PEM file, file refuses an encrypted one and encrypted-file a plain
+// one, so a deployment can insist on encryption. pkcs11 takes an RFC 7512
+// URI, password is the user PIN unless the URI carries one. shares
+// recombines the key from the comma separated share files in key, all
+// decrypted with password, OpenShareSigner takes one password per share.
+// An empty backend is chosen from key: pkcs11 for a pkcs11: URI, else the
+// file backend matching the PEM.
+func OpenSigner(backend, key, password string) (KeySigner, error) {
+	if backend == "" {
+		backend = "file"
+		if strings.HasPrefix(key, "pkcs11:") {
+			backend = "pkcs11"
+		} else if keyPEM, err := os.ReadFile(key); err == nil && encryptedPEM(keyPEM) {
+			backend = "encrypted-file"
+		}
+	}
+	switch backend {
+	case "file":
+		return openFileSigner(key, "", false)
+	case "encrypted-file":
+		return openFileSigner(key, password, true)
+	case "pkcs11":
+		config, err := ParsePKCS11URI(key)
+		if err != nil {
+			return nil, err
+		}
+		if config.PIN == "" {
+			config.PIN = password
+		}
+		return OpenPKCS11Signer(config)
+	case "shares":
+		var passwords []string
+		if password != "" {
+			passwords = []string{password}
+		}
+		return OpenShareSigner(strings.Split(key, ","), passwords)
+	}
+	return nil, fmt.Errorf("unsupported key backend %q, want one of %v", backend, KeyBackends)
+}
+
+// encryptedPEM reports whether the first private key block of keyPEM is
+// encrypted, as PKCS#8 or as a legacy Proc-Type block.
+func encryptedPEM(keyPEM []byte) bool {
+	for {
+		var block *pem.Block
+		block, keyPEM = pem.Decode(keyPEM)
+		if block == nil {
+			return false
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			return true
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return x509.IsEncryptedPEMBlock(block)
+		}
+	}
+}
+
+// fileSigner holds a key in memory, decoded from a PEM file or recombined
+// from shares.
+type fileSigner struct {
+	crypto.Signer
+}
+
+func (s *fileSigner) Close() error {
+	s.Signer = nil
+	return nil
+}
+
+func openFileSigner(path, password string, encrypted bool) (KeySigner, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read private key %q failed, error %v", path, err)
+	}
+	if encryptedPEM(keyPEM) != encrypted {
+		if encrypted {
+			return nil, fmt.Errorf("private key %q is not encrypted", path)
+		}
+		return nil, fmt.Errorf("private key %q is encrypted, use the encrypted-file backend", path)
+	}
+	signer, err := utils.DecodePrivateKey(keyPEM, password)
+	if err != nil {
+		return nil, fmt.Errorf("read private key %q failed, error %v", path, err)
+	}
+	return &fileSigner{Signer: signer}, nil
+}
+
+// PKCS11Config locates a private key on a PKCS#11 token. The token is
+// found by Token, its label, or by SlotID when Token is empty, the key by
+// Label and ID, whichever are set.
+type PKCS11Config struct {
+	Module string
+	Token  string
+	SlotID *uint
+	Label  string
+	ID     []byte
+	PIN    string
+}
+
+// ParsePKCS11URI parses an RFC 7512 URI such as
+// pkcs11:token=DevCA;object=ca0?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=pin.txt
+// with the token, slot-id, object and id path attributes and the
+// module-path, pin-value and pin-source query attributes.
+func ParsePKCS11URI(uri string) (PKCS11Config, error) {
+	var config PKCS11Config
+	rest, ok := strings.CutPrefix(uri, "pkcs11:")
+	if !ok {
+		return config, fmt.Errorf("%q is not a pkcs11 URI", uri)
+	}
+	path, query, _ := strings.Cut(rest, "?")
+	attributes := func(s, separator string, set func(name, value string) error) error {
+		for _, attribute := range strings.Split(s, separator) {
+			if attribute == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(attribute, "=")
+			value, err := url.PathUnescape(value)
+			if err != nil {
+				return fmt.Errorf("pkcs11 URI attribute %q is invalid, error %v", name, err)
+			}
+			if err := set(name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := attributes(path, ";", func(name, value string) error {
+		switch name {
+		case "token":
+			config.Token = value
+		case "object":
+			config.Label = value
+		case "id":
+			config.ID = []byte(value)
+		case "slot-id":
+			slotID, err := strconv.ParseUint(value, 10, 0)
+			if err != nil {
+				return fmt.Errorf("pkcs11 URI slot-id %q is invalid", value)
+			}
+			id := uint(slotID)
+			config.SlotID = &id
+		}
+		return nil
+	})
+	if err != nil {
+		return config, err
+	}
+	err = attributes(query, "&", func(name, value string) error {
+		switch name {
+		case "module-path":
+			config.Module = value
+		case "pin-value":
+			config.PIN = value
+		case "pin-source":
+			pin, err := utils.ResolvePassword("", strings.TrimPrefix(value, "file:"), "")
+			if err != nil {
+				return err
+			}
+			config.PIN = pin
+		}
+		return nil
+	})
+	if err != nil {
+		return config, err
+	}
+	if config.Module == "" {
+		return config, errors.New("pkcs11 URI has no module-path")
+	}
+	if config.Label == "" && len(config.ID) == 0 {
+		return config, errors.New("pkcs11 URI names no object or id")
+	}
+	return config, nil
+}
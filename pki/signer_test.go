@@ -0,0 +1,113 @@
+package pki
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/lx/beego/dev/utils"
+)
+
+func TestOpenSignerFiles(t *testing.T) {
+	dir := t.TempDir()
+	key, err := GenerateKey(nil, "ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainBlock, err := utils.EncodePrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBlock, err := utils.EncodePrivateKey(key, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, encrypted := filepath.Join(dir, "plain.key"), filepath.Join(dir, "encrypted.key")
+	if err := WriteFile(plain, plainBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(encrypted, encryptedBlock); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		backend, key, password string
+		ok                     bool
+	}{
+		{"", plain, "", true},
+		{"", encrypted, "123456", true},
+		{"file", plain, "", true},
+		{"file", encrypted, "123456", false},
+		{"encrypted-file", encrypted, "123456", true},
+		{"encrypted-file", encrypted, "wrong", false},
+		{"encrypted-file", plain, "", false},
+		{"vault", plain, "", false},
+	} {
+		signer, err := OpenSigner(c.backend, c.key, c.password)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("backend %q opened %s, expected an error", c.backend, filepath.Base(c.key))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("backend %q failed to open %s, error %v", c.backend, filepath.Base(c.key), err)
+			continue
+		}
+		if !publicKeyEqual(key.Public(), signer.Public()) {
+			t.Errorf("backend %q opened the wrong key", c.backend)
+		}
+		if err := signer.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	caBlock, err := SignCACert(nil, key, "DevCA", CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertPath := filepath.Join(dir, "ca1.crt")
+	if err := WriteFile(caCertPath, caBlock); err != nil {
+		t.Fatal(err)
+	}
+	authority, err := LoadAuthority(caCertPath, "encrypted-file", encrypted, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer authority.Close()
+	if !bytes.Equal(authority.CertBlock, caBlock) {
+		t.Error("authority holds another certificate")
+	}
+}
+
+func TestParsePKCS11URI(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin.txt")
+	if err := os.WriteFile(pinFile, []byte("1234\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParsePKCS11URI("pkcs11:token=Dev%20CA;object=ca0;id=%01%02?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=file:" + pinFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Token != "Dev CA" || config.Label != "ca0" || !bytes.Equal(config.ID, []byte{1, 2}) || config.PIN != "1234" || config.Module != "/usr/lib/softhsm/libsofthsm2.so" {
+		t.Errorf("parsed %+v", config)
+	}
+	config, err = ParsePKCS11URI("pkcs11:slot-id=3;object=ca0?module-path=m.so&pin-value=0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SlotID == nil || *config.SlotID != 3 || config.PIN != "0000" {
+		t.Errorf("parsed %+v", config)
+	}
+	for _, uri := range []string{
+		"ca0.key",
+		"pkcs11:token=DevCA;object=ca0",
+		"pkcs11:token=DevCA?module-path=m.so",
+		"pkcs11:slot-id=x;object=ca0?module-path=m.so",
+	} {
+		if _, err := ParsePKCS11URI(uri); err == nil {
+			t.Errorf("expected an error parsing %q", uri)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package pki
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SPIFFEID builds spiffe://<trustDomain><path>. The trust domain is lower
+// case letters, digits, dots, dashes and underscores, the path segments
+// letters, digits, dots, dashes and underscores but not . or .. alone.
+func SPIFFEID(trustDomain, path string) (*url.URL, error) {
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return ParseSPIFFEID("spiffe://" + trustDomain + path)
+}
+
+// ParseSPIFFEID parses and checks an ID like spiffe://dev.local/ns/prod/api.
+func ParseSPIFFEID(id string) (*url.URL, error) {
+	rest, ok := strings.CutPrefix(id, "spiffe://")
+	if !ok {
+		return nil, fmt.Errorf("spiffe id %q does not start with spiffe://", id)
+	}
+	trustDomain, path, _ := strings.Cut(rest, "/")
+	if trustDomain == "" {
+		return nil, fmt.Errorf("spiffe id %q has no trust domain", id)
+	}
+	for _, c := range trustDomain {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return nil, fmt.Errorf("spiffe id %q has an invalid trust domain, use lower case letters, digits, '.', '-' and '_'", id)
+		}
+	}
+	if path != "" {
+		for _, segment := range strings.Split(path, "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				return nil, fmt.Errorf("spiffe id %q has an empty, . or .. path segment", id)
+			}
+			for _, c := range segment {
+				if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+					return nil, fmt.Errorf("spiffe id %q has an invalid path, use letters, digits, '.', '-' and '_'", id)
+				}
+			}
+		}
+	}
+	return &url.URL{Scheme: "spiffe", Host: trustDomain, Path: strings.TrimSuffix("/"+path, "/")}, nil
+}
+
+// SPIFFEIDOf returns the SPIFFE ID of an X.509 SVID, which carries it as
+// its only URI SAN.
+func SPIFFEIDOf(cert *x509.Certificate) (*url.URL, error) {
+	if len(cert.URIs) != 1 {
+		return nil, fmt.Errorf("certificate %q has %d URI SANs, an SVID has exactly one", cert.Subject.CommonName, len(cert.URIs))
+	}
+	return ParseSPIFFEID(cert.URIs[0].String())
+}
+
+// InTrustDomain reports whether id belongs to trustDomain.
+func InTrustDomain(id *url.URL, trustDomain string) bool {
+	return id.Scheme == "spiffe" && id.Host == trustDomain
+}
@@ -0,0 +1,30 @@
+package pki
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+)
+
+func TestSPIFFEID(t *testing.T) {
+	id, err := SPIFFEID("dev.local", "ns/prod/sa/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != "spiffe://dev.local/ns/prod/sa/api" || !InTrustDomain(id, "dev.local") || InTrustDomain(id, "prod.local") {
+		t.Errorf("id is %s", id)
+	}
+	for _, bad := range []string{"https://dev.local/api", "spiffe:///api", "spiffe://Dev.local/api", "spiffe://dev.local/a//b", "spiffe://dev.local/../api", "spiffe://dev.local/api/", "spiffe://dev.local/a?b"} {
+		if _, err := ParseSPIFFEID(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+	cert := &x509.Certificate{URIs: []*url.URL{id}}
+	if got, err := SPIFFEIDOf(cert); err != nil || got.String() != id.String() {
+		t.Errorf("SPIFFEIDOf is %v, error %v", got, err)
+	}
+	cert.URIs = append(cert.URIs, id)
+	if _, err := SPIFFEIDOf(cert); err == nil {
+		t.Error("expected an error for two URI SANs")
+	}
+}
@@ -1,8 +1,29 @@
 package utils
 
+import "time"
+
 type CertConfig struct {
 	ServerCert     string
 	ServerKey      string
 	ServerPassword string
 	CaCert         string
+	// CRLFiles, OCSPURL and OCSPRequired configure the revocation checks
+	// of peer certificates, CRLReload how often the CRL files are reread.
+	CRLFiles     []string
+	CRLReload    time.Duration
+	OCSPURL      string
+	OCSPRequired bool
+	// TrustDomain restricts peers to SPIFFE IDs spiffe://<TrustDomain>/...,
+	// empty accepts peers with or without one.
+	TrustDomain string
+}
+
+// RevocationChecker returns a checker with the CRLs loaded. Even without
+// CRLs or OCSPURL it asks the AIA OCSP URL of certificates that have one.
+func (c CertConfig) RevocationChecker() (*RevocationChecker, error) {
+	checker := &RevocationChecker{CRLFiles: c.CRLFiles, OCSPURL: c.OCSPURL, OCSPRequired: c.OCSPRequired}
+	if err := checker.Reload(); err != nil {
+		return nil, err
+	}
+	return checker, nil
 }
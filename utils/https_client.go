@@ -17,15 +17,20 @@ func GetRequest(url string, certConfig *CertConfig) {
 		pool.AppendCertsFromPEM(caCert)
 	}
 	certificates := make([]tls.Certificate, 1, 1)
-	if serverCrt, err := tls.LoadX509KeyPair(certConfig.ServerCert, certConfig.ServerKey); err != nil {
+	if serverCrt, err := LoadX509KeyPair(certConfig.ServerCert, certConfig.ServerKey, certConfig.ServerPassword); err != nil {
 		log.Fatalf("load server cert %q, key %q failed, error %v", certConfig.ServerCert, certConfig.ServerKey, err)
 	} else {
 		certificates[0] = serverCrt
 	}
+	checker, err := certConfig.RevocationChecker()
+	if err != nil {
+		log.Fatalf("load revocation lists failed, error %v", err)
+	}
 	transport := &http.Transport{
 		TLSClientConfig: &tls.Config{
-			RootCAs:      pool,
-			Certificates: certificates,
+			RootCAs:               pool,
+			Certificates:          certificates,
+			VerifyPeerCertificate: checker.VerifyPeerCertificate,
 			//            InsecureSkipVerify: false,
 		},
 	}
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IndexEntry is one line of an openssl ca style index.txt database:
+// status, expiry, revocation time[,reason], serial, file and subject,
+// separated by tabs.
+type IndexEntry struct {
+	Status         string
+	Expiry         time.Time
+	RevocationTime time.Time
+	Reason         string
+	Serial         *big.Int
+	File           string
+	Subject        string
+}
+
+// CAPrefix strips the extension of a CA certificate path, conf/certs/ca1.crt
+// keeps its database and CRLs in conf/certs/ca1.index.txt, ca1.crl and so on.
+func CAPrefix(caCertPath string) string {
+	return strings.TrimSuffix(caCertPath, filepath.Ext(caCertPath))
+}
+
+func IndexPath(caCertPath string) string {
+	return CAPrefix(caCertPath) + ".index.txt"
+}
+
+func formatIndexTime(t time.Time) string {
+	t = t.UTC()
+	if t.Year() >= 2050 {
+		return t.Format("20060102150405Z")
+	}
+	return t.Format("060102150405Z")
+}
+
+func parseIndexTime(value string) (time.Time, error) {
+	if len(value) == len("20060102150405Z") {
+		return time.Parse("20060102150405Z", value)
+	}
+	return time.Parse("060102150405Z", value)
+}
+
+func FormatSerial(serial *big.Int) string {
+	hex := strings.ToUpper(serial.Text(16))
+	if len(hex)%2 == 1 {
+		hex = "0" + hex
+	}
+	return hex
+}
+
+// ParseSerial reads a hexadecimal serial as printed by openssl x509 -serial,
+// with or without colons and the serial= prefix.
+func ParseSerial(value string) (*big.Int, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "serial=")
+	value = strings.TrimPrefix(strings.ReplaceAll(value, ":", ""), "0x")
+	serial, ok := new(big.Int).SetString(value, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid hexadecimal serial %q", value)
+	}
+	return serial, nil
+}
+
+// RevocationReasons are the RFC 5280 reason codes under their openssl names.
+var RevocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"CACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"removeFromCRL":        8,
+	"privilegeWithdrawn":   9,
+	"AACompromise":         10,
+}
+
+func RevocationReasonCode(reason string) (int, error) {
+	if reason == "" {
+		return 0, nil
+	}
+	for name, code := range RevocationReasons {
+		if strings.EqualFold(name, reason) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason %q", reason)
+}
+
+func RevocationReasonName(code int) string {
+	for name, c := range RevocationReasons {
+		if c == code {
+			return name
+		}
+	}
+	return "unspecified"
+}
+
+var subjectShortNames = map[string]string{
+	"2.5.4.6":                    "C",
+	"2.5.4.8":                    "ST",
+	"2.5.4.7":                    "L",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "serialNumber",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+	"0.9.2342.19200300.100.1.25": "DC",
+}
+
+// OpensslSubject formats a name as /C=CN/ST=BeiJing/.../CN=name.
+func OpensslSubject(name pkix.Name) string {
+	builder := &strings.Builder{}
+	for _, rdn := range name.ToRDNSequence() {
+		for _, attribute := range rdn {
+			key, ok := subjectShortNames[attribute.Type.String()]
+			if !ok {
+				key = attribute.Type.String()
+			}
+			value := strings.ReplaceAll(fmt.Sprint(attribute.Value), "/", `\/`)
+			fmt.Fprintf(builder, "/%s=%s", key, value)
+		}
+	}
+	return builder.String()
+}
+
+func NewIndexEntry(cert *x509.Certificate, file string) *IndexEntry {
+	if file == "" {
+		file = "unknown"
+	}
+	return &IndexEntry{
+		Status:  "V",
+		Expiry:  cert.NotAfter,
+		Serial:  cert.SerialNumber,
+		File:    file,
+		Subject: OpensslSubject(cert.Subject),
+	}
+}
+
+func (e *IndexEntry) String() string {
+	revocation := ""
+	if e.Status == "R" {
+		revocation = formatIndexTime(e.RevocationTime)
+		if e.Reason != "" {
+			revocation += "," + e.Reason
+		}
+	}
+	return strings.Join([]string{e.Status, formatIndexTime(e.Expiry), revocation, FormatSerial(e.Serial), e.File, e.Subject}, "\t")
+}
+
+func ParseIndexEntry(line string) (*IndexEntry, error) {
+	fields := strings.SplitN(line, "\t", 6)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("index line %q has %d fields, want 6", line, len(fields))
+	}
+	entry := &IndexEntry{Status: fields[0], File: fields[4], Subject: fields[5]}
+	var err error
+	if entry.Expiry, err = parseIndexTime(fields[1]); err != nil {
+		return nil, fmt.Errorf("index line %q has invalid expiry, error %v", line, err)
+	}
+	if fields[2] != "" {
+		revocation := strings.SplitN(fields[2], ",", 2)
+		if entry.RevocationTime, err = parseIndexTime(revocation[0]); err != nil {
+			return nil, fmt.Errorf("index line %q has invalid revocation time, error %v", line, err)
+		}
+		if len(revocation) == 2 {
+			entry.Reason = revocation[1]
+		}
+	}
+	if entry.Serial, err = ParseSerial(fields[3]); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ReadIndex returns the entries of the database at path, a missing file is
+// an empty database.
+func ReadIndex(path string) ([]*IndexEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*IndexEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := ParseIndexEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("read index %q failed, error %v", path, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func WriteIndex(path string, entries []*IndexEntry) error {
+	builder := &strings.Builder{}
+	for _, entry := range entries {
+		builder.WriteString(entry.String())
+		builder.WriteString("\n")
+	}
+	return os.WriteFile(path, []byte(builder.String()), 0600)
+}
+
+func FindIndexEntry(entries []*IndexEntry, serial *big.Int) *IndexEntry {
+	for _, entry := range entries {
+		if entry.Serial.Cmp(serial) == 0 {
+			return entry
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSPAuthority is a CA the responder answers for: its certificate, the
+// delegated signer written next to it as <ca>.ocsp.crt and <ca>.ocsp.key,
+// and the <ca>.index.txt revocation database.
+type OCSPAuthority struct {
+	Cert      *x509.Certificate
+	Signer    *x509.Certificate
+	SignerKey crypto.Signer
+	IndexFile string
+}
+
+// LoadOCSPAuthority reads the CA at caCertPath and its delegated signer,
+// password decrypts an encrypted signer key.
+func LoadOCSPAuthority(caCertPath, password string) (*OCSPAuthority, error) {
+	caCert, err := readCertificate(caCertPath)
+	if err != nil {
+		return nil, err
+	}
+	prefix := CAPrefix(caCertPath)
+	signer, err := readCertificate(prefix + ".ocsp.crt")
+	if err != nil {
+		return nil, err
+	}
+	if err := signer.CheckSignatureFrom(caCert); err != nil {
+		return nil, fmt.Errorf("ocsp signer %q was not issued by %q, error %v", prefix+".ocsp.crt", caCertPath, err)
+	}
+	signerKey, err := ReadPrivateKey(prefix+".ocsp.key", password)
+	if err != nil {
+		return nil, err
+	}
+	return &OCSPAuthority{Cert: caCert, Signer: signer, SignerKey: signerKey, IndexFile: IndexPath(caCertPath)}, nil
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate %q failed, error %v", path, err)
+	}
+	if block, _ := pem.Decode(content); block != nil {
+		content = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate %q failed, error %v", path, err)
+	}
+	return cert, nil
+}
+
+// matches reports whether the request names this CA as the issuer.
+func (a *OCSPAuthority) matches(request *ocsp.Request) bool {
+	if !request.HashAlgorithm.Available() {
+		return false
+	}
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(a.Cert.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return false
+	}
+	nameHash := request.HashAlgorithm.New()
+	nameHash.Write(a.Cert.RawSubject)
+	keyHash := request.HashAlgorithm.New()
+	keyHash.Write(publicKeyInfo.PublicKey.RightAlign())
+	return bytes.Equal(nameHash.Sum(nil), request.IssuerNameHash) && bytes.Equal(keyHash.Sum(nil), request.IssuerKeyHash)
+}
+
+// OCSPResponder answers RFC 6960 status requests, POSTed or base64 encoded
+// in the GET path, for the certificates issued by its authorities. It is
+// mounted one level deep, e.g. at /ocsp, so GET requests are /ocsp/<base64>.
+type OCSPResponder struct {
+	Authorities []*OCSPAuthority
+	// Validity is the time until the next update a response announces.
+	Validity time.Duration
+}
+
+// Respond builds the signed response to a DER encoded request. Failures are
+// reported as OCSP error responses, never as HTTP errors.
+func (r *OCSPResponder) Respond(der []byte) []byte {
+	request, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse
+	}
+	var authority *OCSPAuthority
+	for _, a := range r.Authorities {
+		if a.matches(request) {
+			authority = a
+			break
+		}
+	}
+	if authority == nil {
+		return ocsp.UnauthorizedErrorResponse
+	}
+	entries, err := ReadIndex(authority.IndexFile)
+	if err != nil {
+		log.Printf("read index %q failed, error %v", authority.IndexFile, err)
+		return ocsp.InternalErrorErrorResponse
+	}
+	now := time.Now()
+	validity := r.Validity
+	if validity <= 0 {
+		validity = time.Hour
+	}
+	template := ocsp.Response{
+		SerialNumber: request.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(validity),
+		Certificate:  authority.Signer,
+		// serials the CA database never recorded were not issued here
+		Status: ocsp.Unknown,
+	}
+	entry := FindIndexEntry(entries, request.SerialNumber)
+	switch {
+	case entry == nil:
+	case entry.Status == "R":
+		template.Status = ocsp.Revoked
+		template.RevokedAt = entry.RevocationTime
+		if template.RevocationReason, err = RevocationReasonCode(entry.Reason); err != nil {
+			template.RevocationReason = ocsp.Unspecified
+		}
+	default:
+		template.Status = ocsp.Good
+	}
+	response, err := ocsp.CreateResponse(authority.Cert, authority.Signer, template, authority.SignerKey)
+	if err != nil {
+		log.Printf("create ocsp response for serial %s failed, error %v", FormatSerial(request.SerialNumber), err)
+		return ocsp.InternalErrorErrorResponse
+	}
+	return response
+}
+
+func (r *OCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var der []byte
+	var err error
+	switch req.Method {
+	case http.MethodPost:
+		der, err = io.ReadAll(io.LimitReader(req.Body, 64*1024))
+	case http.MethodGet:
+		encoded := strings.TrimPrefix(req.URL.EscapedPath(), "/")
+		encoded = encoded[strings.Index(encoded, "/")+1:]
+		if encoded, err = url.PathUnescape(encoded); err == nil {
+			der, err = base64.StdEncoding.DecodeString(encoded)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	response := ocsp.MalformedRequestErrorResponse
+	if err == nil {
+		response = r.Respond(der)
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(response)
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+)
+
+// EncodePrivateKey returns privateKey as a PKCS#8 PEM block. With a non
+// empty password the block is an ENCRYPTED PRIVATE KEY protected with
+// PBES2 (PBKDF2-SHA256, AES-256-CBC), the same as openssl pkcs8 -topk8.
+func EncodePrivateKey(privateKey crypto.PrivateKey, password string) ([]byte, error) {
+	if password == "" {
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	der, err := pkcs8.MarshalPrivateKey(privateKey, []byte(password), nil)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecodePrivateKey parses the first private key PEM block in keyPEM. It
+// accepts PKCS#8 (plain or encrypted), PKCS#1 and SEC 1 keys, including the
+// legacy Proc-Type encrypted blocks written by openssl rsa -aes256.
+func DecodePrivateKey(keyPEM []byte, password string) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, keyPEM = pem.Decode(keyPEM)
+		if block == nil {
+			return nil, errors.New("no private key PEM block found")
+		}
+		if block.Type != "PRIVATE KEY" && !strings.HasSuffix(block.Type, " PRIVATE KEY") {
+			continue
+		}
+		der := block.Bytes
+		if x509.IsEncryptedPEMBlock(block) {
+			if password == "" {
+				return nil, errors.New("private key is encrypted but no password was given")
+			}
+			var err error
+			if der, err = x509.DecryptPEMBlock(block, []byte(password)); err != nil {
+				return nil, fmt.Errorf("decrypt private key failed, error %v", err)
+			}
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			if password == "" {
+				return nil, errors.New("private key is encrypted but no password was given")
+			}
+			key, err := pkcs8.ParsePKCS8PrivateKey(der, []byte(password))
+			if err != nil {
+				return nil, fmt.Errorf("decrypt private key failed, error %v", err)
+			}
+			return toSigner(key)
+		}
+		return parsePrivateKey(der)
+	}
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return toSigner(key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+func toSigner(key interface{}) (crypto.Signer, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// ReadPrivateKey reads and decodes the private key stored at path.
+func ReadPrivateKey(path, password string) (crypto.Signer, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := DecodePrivateKey(keyPEM, password)
+	if err != nil {
+		return nil, fmt.Errorf("read private key %q failed, error %v", path, err)
+	}
+	return key, nil
+}
+
+// LoadX509KeyPair works like tls.LoadX509KeyPair but also accepts an
+// encrypted key file, decrypted with password.
+func LoadX509KeyPair(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := ReadPrivateKey(keyFile, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := EncodePrivateKey(key, "")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// ResolvePassword returns the first password found in value, the file at
+// passwordFile and the environment variable envName. A trailing newline in
+// the password file is ignored.
+func ResolvePassword(value, passwordFile, envName string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	if passwordFile != "" {
+		content, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("read password file %q failed, error %v", passwordFile, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	return os.Getenv(envName), nil
+}
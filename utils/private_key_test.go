@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+func TestEncodeDecodePrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"", "secret"} {
+		keyPEM, err := EncodePrivateKey(key, password)
+		if err != nil {
+			t.Fatalf("encode with password %q failed, error %v", password, err)
+		}
+		decoded, err := DecodePrivateKey(keyPEM, password)
+		if err != nil {
+			t.Fatalf("decode with password %q failed, error %v", password, err)
+		}
+		if !key.Equal(decoded) {
+			t.Errorf("decoded key with password %q does not match", password)
+		}
+	}
+	keyPEM, err := EncodePrivateKey(key, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodePrivateKey(keyPEM, ""); err == nil {
+		t.Error("expected an error decoding an encrypted key without password")
+	}
+	if _, err := DecodePrivateKey(keyPEM, "wrong"); err == nil {
+		t.Error("expected an error decoding an encrypted key with a wrong password")
+	}
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// RevocationChecker rejects peer certificates listed in the configured CRL
+// files or reported revoked by an OCSP responder. Its VerifyPeerCertificate
+// method plugs into tls.Config, so revoked peers fail the handshake.
+type RevocationChecker struct {
+	CRLFiles []string
+	// OCSPURL is asked about every certificate of the chain that no current
+	// CRL covers. Without it the AIA OCSP URL of the certificate is used, if
+	// it has one.
+	OCSPURL string
+	// OCSPRequired rejects the peer when the responder cannot be reached
+	// or does not know the certificate, instead of only logging it.
+	OCSPRequired bool
+	// OCSPCacheTTL keeps answers without a next update, and failures, for
+	// this long, 5 minutes when 0. Answers with one are kept until then.
+	OCSPCacheTTL time.Duration
+	Client       *http.Client
+
+	mu   sync.RWMutex
+	crls map[string]*x509.RevocationList
+
+	ocspMu    sync.Mutex
+	ocspCache map[string]ocspAnswer
+}
+
+// ocspAnswer is a cached OCSP status, or the error asking for it.
+type ocspAnswer struct {
+	status  int
+	err     error
+	expires time.Time
+}
+
+// Reload reads every CRL file again. A file that fails to load keeps the
+// CRL read from it last time, so a half written file never unrevokes.
+func (c *RevocationChecker) Reload() error {
+	c.mu.RLock()
+	crls := make(map[string]*x509.RevocationList, len(c.CRLFiles))
+	for path, crl := range c.crls {
+		crls[path] = crl
+	}
+	c.mu.RUnlock()
+	var errs []error
+	for _, path := range c.CRLFiles {
+		crl, err := readCRL(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			log.Printf("crl %q is past its next update %v", path, crl.NextUpdate)
+		}
+		crls[path] = crl
+	}
+	c.mu.Lock()
+	c.crls = crls
+	c.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// ReloadEvery reloads the CRL files every interval until stop is closed.
+func (c *RevocationChecker) ReloadEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Reload(); err != nil {
+				log.Printf("reload crl failed, error %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func readCRL(path string) (*x509.RevocationList, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read crl %q failed, error %v", path, err)
+	}
+	if block, _ := pem.Decode(content); block != nil {
+		content = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse crl %q failed, error %v", path, err)
+	}
+	return crl, nil
+}
+
+// VerifyPeerCertificate checks each certificate of the verified chains
+// against its issuer's CRL and the OCSP responder.
+func (c *RevocationChecker) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for i := 0; i+1 < len(chain); i++ {
+			if err := c.Check(chain[i], chain[i+1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Check reports an error when cert, issued by issuer, is revoked.
+func (c *RevocationChecker) Check(cert, issuer *x509.Certificate) error {
+	c.mu.RLock()
+	crls := c.crls
+	c.mu.RUnlock()
+	now := time.Now()
+	covered := false
+	for _, crl := range crls {
+		if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) || crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate %q serial %X is revoked since %v", cert.Subject.CommonName, cert.SerialNumber, entry.RevocationTime)
+			}
+		}
+		if crl.NextUpdate.IsZero() || now.Before(crl.NextUpdate) {
+			covered = true
+		}
+	}
+	ocspURL := c.OCSPURL
+	if ocspURL == "" && len(cert.OCSPServer) > 0 {
+		ocspURL = cert.OCSPServer[0]
+	}
+	if covered || ocspURL == "" {
+		return nil
+	}
+	status, err := c.cachedOCSPStatus(ocspURL, cert, issuer, now)
+	if err != nil {
+		if c.OCSPRequired {
+			return fmt.Errorf("ocsp check of %q failed, error %v", cert.Subject.CommonName, err)
+		}
+		log.Printf("ocsp check of %q failed, error %v", cert.Subject.CommonName, err)
+		return nil
+	}
+	switch status {
+	case ocsp.Revoked:
+		return fmt.Errorf("certificate %q serial %X is revoked according to ocsp", cert.Subject.CommonName, cert.SerialNumber)
+	case ocsp.Unknown:
+		if c.OCSPRequired {
+			return fmt.Errorf("ocsp responder does not know certificate %q serial %X", cert.Subject.CommonName, cert.SerialNumber)
+		}
+	}
+	return nil
+}
+
+// cachedOCSPStatus asks the responder about cert unless an answer for the
+// same issuer and serial is still cached, so handshakes do not wait on the
+// responder each time.
+func (c *RevocationChecker) cachedOCSPStatus(ocspURL string, cert, issuer *x509.Certificate, now time.Time) (int, error) {
+	issuerHash := sha256.Sum256(issuer.Raw)
+	key := fmt.Sprintf("%x/%X", issuerHash, cert.SerialNumber)
+	c.ocspMu.Lock()
+	answer, ok := c.ocspCache[key]
+	c.ocspMu.Unlock()
+	if ok && now.Before(answer.expires) {
+		return answer.status, answer.err
+	}
+	ttl := c.OCSPCacheTTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	answer = ocspAnswer{expires: now.Add(ttl)}
+	var nextUpdate time.Time
+	answer.status, nextUpdate, answer.err = c.ocspStatus(ocspURL, cert, issuer)
+	if answer.err == nil && nextUpdate.After(now) {
+		answer.expires = nextUpdate
+	}
+	c.ocspMu.Lock()
+	defer c.ocspMu.Unlock()
+	if c.ocspCache == nil {
+		c.ocspCache = map[string]ocspAnswer{}
+	}
+	for cached, old := range c.ocspCache {
+		if !now.Before(old.expires) {
+			delete(c.ocspCache, cached)
+		}
+	}
+	c.ocspCache[key] = answer
+	return answer.status, answer.err
+}
+
+func (c *RevocationChecker) ocspStatus(ocspURL string, cert, issuer *x509.Certificate) (int, time.Time, error) {
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return ocsp.Unknown, time.Time{}, err
+	}
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	resp, err := client.Post(ocspURL, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return ocsp.Unknown, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ocsp.Unknown, time.Time{}, fmt.Errorf("ocsp responder %q returned %s", ocspURL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ocsp.Unknown, time.Time{}, err
+	}
+	response, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return ocsp.Unknown, time.Time{}, err
+	}
+	return response.Status, response.NextUpdate, nil
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func testCert(t *testing.T, template, parent *x509.Certificate, key *ecdsa.PrivateKey, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRevocationCheckerCRL(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	ca := testCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, caKey, nil)
+	revoked := testCert(t, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "revoked"}, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}, ca, leafKey, caKey)
+	valid := testCert(t, &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "valid"}, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}, ca, leafKey, caKey)
+
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: revoked.SerialNumber, RevocationTime: now}},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlFile := filepath.Join(t.TempDir(), "ca.crl")
+	if err := os.WriteFile(crlFile, crl, 0600); err != nil {
+		t.Fatal(err)
+	}
+	checker, err := CertConfig{CRLFiles: []string{crlFile}}.RevocationChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.VerifyPeerCertificate(nil, [][]*x509.Certificate{{revoked, ca}}); err == nil {
+		t.Error("expected the revoked certificate to be rejected")
+	}
+	if err := checker.VerifyPeerCertificate(nil, [][]*x509.Certificate{{valid, ca}}); err != nil {
+		t.Errorf("valid certificate rejected, error %v", err)
+	}
+
+	// a broken file on reload keeps the last good CRL
+	if err := os.WriteFile(crlFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Reload(); err == nil {
+		t.Error("expected an error reloading a broken crl")
+	}
+	if err := checker.Check(revoked, ca); err == nil {
+		t.Error("expected the revoked certificate to stay rejected after a failed reload")
+	}
+}
+
+func TestRevocationCheckerOCSPCache(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	ca := testCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, caKey, nil)
+	revoked := testCert(t, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "revoked"}, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}, ca, leafKey, caKey)
+
+	requests := 0
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		response, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       ocsp.Revoked,
+			SerialNumber: revoked.SerialNumber,
+			ThisUpdate:   now,
+			NextUpdate:   now.Add(time.Hour),
+			RevokedAt:    now,
+		}, caKey)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(response)
+	}))
+	defer responder.Close()
+
+	checker := &RevocationChecker{OCSPURL: responder.URL}
+	for i := 0; i < 3; i++ {
+		if err := checker.Check(revoked, ca); err == nil {
+			t.Error("expected the certificate revoked by ocsp to be rejected")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("responder asked %d times, want the answer cached after once", requests)
+	}
+
+	// a current crl of the issuer makes the ocsp request unnecessary
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: now, NextUpdate: now.Add(time.Hour)}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlFile := filepath.Join(t.TempDir(), "ca.crl")
+	if err := os.WriteFile(crlFile, crl, 0600); err != nil {
+		t.Fatal(err)
+	}
+	checker = &RevocationChecker{CRLFiles: []string{crlFile}, OCSPURL: responder.URL}
+	if err := checker.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	requests = 0
+	if err := checker.Check(revoked, ca); err != nil {
+		t.Errorf("certificate missing from the current crl rejected, error %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("responder asked %d times although a current crl covers the issuer", requests)
+	}
+
+	// an unreachable responder is not asked again on every handshake
+	responder.Close()
+	checker = &RevocationChecker{OCSPURL: responder.URL, OCSPRequired: true}
+	for i := 0; i < 3; i++ {
+		if err := checker.Check(revoked, ca); err == nil {
+			t.Error("expected the required ocsp check to fail")
+		}
+	}
+	if answer, ok := checker.ocspCache[fmt.Sprintf("%x/%X", sha256.Sum256(ca.Raw), revoked.SerialNumber)]; !ok || answer.err == nil {
+		t.Error("failed ocsp answer not cached")
+	}
+}